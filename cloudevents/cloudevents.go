@@ -1,10 +1,49 @@
+// Package cloudevents provides the event type of CloudEvents 1.0 and
+// its JSON format.
 package cloudevents
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/url"
+	"strings"
 	"time"
 )
 
+const (
+	// SpecVersion is the version of CloudEvents specification.
+	SpecVersion = "1.0"
+
+	// ModeBinary is the content mode that carries attributes in
+	// HTTP headers and the data in the HTTP body.
+	ModeBinary = "binary"
+	// ModeStructured is the content mode that carries the whole
+	// event in the HTTP body with JSON format.
+	ModeStructured = "structured"
+
+	// StructuredContentType is the content type of the event in
+	// structured content mode.
+	StructuredContentType = "application/cloudevents+json"
+)
+
+// reserved is the list of attribute names that can not be used as
+// extension attributes.
+var reserved = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"subject":         true,
+	"time":            true,
+	"data":            true,
+	"data_base64":     true,
+}
+
 type Event struct {
 	ID     string
 	Source url.URL
@@ -14,4 +53,259 @@ type Event struct {
 	DataSchema      url.URL
 	Subject         string
 	Time            *time.Time
+
+	Extensions map[string]string
+	Data       []byte
+}
+
+// Validate returns an error if the required attributes of the event
+// are missing or any attribute has invalid value.
+func (e *Event) Validate() error {
+	if e.ID == "" {
+		return errors.New("id is required")
+	}
+	if e.Source.String() == "" {
+		return errors.New("source is required")
+	}
+	if e.Type == "" {
+		return errors.New("type is required")
+	}
+
+	if e.DataContentType != "" {
+		_, _, err := mime.ParseMediaType(e.DataContentType)
+		if err != nil {
+			return fmt.Errorf("invalid datacontenttype: %s", e.DataContentType)
+		}
+	}
+
+	for name := range e.Extensions {
+		if !ValidExtensionName(name) {
+			return fmt.Errorf("invalid extension name: %s", name)
+		}
+		if reserved[name] {
+			return fmt.Errorf("reserved extension name: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// SetExtension sets the value of the extension attribute with the
+// specified name. The extension attribute is removed if the value is
+// empty.
+func (e *Event) SetExtension(name, value string) {
+	if value == "" {
+		delete(e.Extensions, name)
+		return
+	}
+
+	if e.Extensions == nil {
+		e.Extensions = map[string]string{}
+	}
+	e.Extensions[name] = value
+}
+
+// MarshalJSON returns the event encoded in the JSON format of
+// CloudEvents. The data is embedded as JSON value if the data
+// content type is JSON, otherwise it is embedded as base64 string.
+func (e Event) MarshalJSON() ([]byte, error) {
+	err := e.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{
+		"specversion": SpecVersion,
+		"id":          e.ID,
+		"source":      e.Source.String(),
+		"type":        e.Type,
+	}
+
+	if e.DataContentType != "" {
+		m["datacontenttype"] = e.DataContentType
+	}
+	if e.DataSchema.String() != "" {
+		m["dataschema"] = e.DataSchema.String()
+	}
+	if e.Subject != "" {
+		m["subject"] = e.Subject
+	}
+	if e.Time != nil {
+		m["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+
+	for name, value := range e.Extensions {
+		m[name] = value
+	}
+
+	if len(e.Data) > 0 {
+		if isJSON(e.DataContentType) && json.Valid(e.Data) {
+			m["data"] = json.RawMessage(e.Data)
+		} else {
+			m["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes the event from the JSON format of CloudEvents.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return err
+	}
+
+	var ev Event
+
+	attrs := map[string]*string{}
+	for _, name := range []string{"specversion", "id", "source", "type", "datacontenttype", "dataschema", "subject", "time"} {
+		raw, ok := m[name]
+		if !ok {
+			continue
+		}
+
+		var v string
+		err := json.Unmarshal(raw, &v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", name, raw)
+		}
+		attrs[name] = &v
+	}
+
+	if attrs["specversion"] == nil || *attrs["specversion"] != SpecVersion {
+		return errors.New("unsupported specversion")
+	}
+
+	if v := attrs["id"]; v != nil {
+		ev.ID = *v
+	}
+	if v := attrs["type"]; v != nil {
+		ev.Type = *v
+	}
+	if v := attrs["datacontenttype"]; v != nil {
+		ev.DataContentType = *v
+	}
+	if v := attrs["subject"]; v != nil {
+		ev.Subject = *v
+	}
+
+	if v := attrs["source"]; v != nil {
+		s, err := url.Parse(*v)
+		if err != nil {
+			return fmt.Errorf("invalid source: %s", *v)
+		}
+		ev.Source = *s
+	}
+
+	if v := attrs["dataschema"]; v != nil {
+		s, err := url.Parse(*v)
+		if err != nil {
+			return fmt.Errorf("invalid dataschema: %s", *v)
+		}
+		ev.DataSchema = *s
+	}
+
+	if v := attrs["time"]; v != nil {
+		t, err := time.Parse(time.RFC3339Nano, *v)
+		if err != nil {
+			return fmt.Errorf("invalid time: %s", *v)
+		}
+		ev.Time = &t
+	}
+
+	data, hasData := m["data"]
+	data64, hasData64 := m["data_base64"]
+	if hasData && hasData64 {
+		return errors.New("data and data_base64 can not be used together")
+	}
+
+	if hasData {
+		var s string
+		if !isJSON(ev.DataContentType) && json.Unmarshal(data, &s) == nil {
+			ev.Data = []byte(s)
+		} else {
+			ev.Data = []byte(data)
+		}
+	}
+
+	if hasData64 {
+		var s string
+		err := json.Unmarshal(data64, &s)
+		if err != nil {
+			return fmt.Errorf("invalid data_base64: %s", data64)
+		}
+
+		ev.Data, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("invalid data_base64: %s", err)
+		}
+	}
+
+	for name, raw := range m {
+		if reserved[name] {
+			continue
+		}
+
+		var v interface{}
+		err := json.Unmarshal(raw, &v)
+		if err != nil {
+			return err
+		}
+
+		if ev.Extensions == nil {
+			ev.Extensions = map[string]string{}
+		}
+
+		switch v := v.(type) {
+		case string:
+			ev.Extensions[name] = v
+		case bool, float64:
+			ev.Extensions[name] = string(raw)
+		default:
+			return fmt.Errorf("invalid extension attribute: %s", name)
+		}
+	}
+
+	err = ev.Validate()
+	if err != nil {
+		return err
+	}
+
+	*e = ev
+
+	return nil
+}
+
+// ValidExtensionName returns true if the specified name can be used
+// as the name of extension attribute. The name must consist of
+// lower-case letters or digits.
+func ValidExtensionName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isJSON returns true if the specified content type is JSON.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
 }
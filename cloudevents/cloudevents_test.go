@@ -0,0 +1,146 @@
+package cloudevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newEvent(contentType string, data []byte) *Event {
+	s, _ := url.Parse("https://example.com/source")
+	t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	return &Event{
+		ID:              "a89b61a2-5644-487a-8a86-144855c5dce8",
+		Source:          *s,
+		Type:            "com.example.test",
+		DataContentType: contentType,
+		Subject:         "test",
+		Time:            &t,
+		Extensions:      map[string]string{"comexampleext": "value"},
+		Data:            data,
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		key         string
+		value       string
+	}{
+		{"json", "application/json", []byte(`{"foo":"bar"}`), "data", `{"foo":"bar"}`},
+		{"json-suffix", "application/vnd.example+json", []byte(`[1,2]`), "data", `[1,2]`},
+		{"form", "application/x-www-form-urlencoded", []byte("foo=bar"), "data_base64", `"Zm9vPWJhcg=="`},
+		{"invalid-json", "application/json", []byte("{"), "data_base64", `"ew=="`},
+	}
+
+	for _, test := range tests {
+		buf, err := json.Marshal(newEvent(test.contentType, test.data))
+		if err != nil {
+			t.Fatalf("[%s] marshal error: %v", test.name, err)
+		}
+
+		var m map[string]json.RawMessage
+		err = json.Unmarshal(buf, &m)
+		if err != nil {
+			t.Fatalf("[%s] invalid JSON: %v", test.name, err)
+		}
+
+		if string(m["specversion"]) != `"1.0"` {
+			t.Errorf("[%s] invalid specversion: %s", test.name, m["specversion"])
+		}
+		if string(m["comexampleext"]) != `"value"` {
+			t.Errorf("[%s] invalid extension: %s", test.name, m["comexampleext"])
+		}
+		if string(m[test.key]) != test.value {
+			t.Errorf("[%s] invalid %s: %s", test.name, test.key, m[test.key])
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"json", "application/json", []byte(`{"foo":"bar"}`)},
+		{"form", "application/x-www-form-urlencoded", []byte("foo=bar&baz=qux")},
+		{"empty", "application/json", nil},
+	}
+
+	for _, test := range tests {
+		ev := newEvent(test.contentType, test.data)
+
+		buf, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatalf("[%s] marshal error: %v", test.name, err)
+		}
+
+		var got Event
+		err = json.Unmarshal(buf, &got)
+		if err != nil {
+			t.Fatalf("[%s] unmarshal error: %v", test.name, err)
+		}
+
+		if got.ID != ev.ID || got.Type != ev.Type || got.Source.String() != ev.Source.String() || got.Subject != ev.Subject {
+			t.Errorf("[%s] invalid attributes: %v", test.name, got)
+		}
+		if got.Time == nil || !got.Time.Equal(*ev.Time) {
+			t.Errorf("[%s] invalid time: %v", test.name, got.Time)
+		}
+		if got.Extensions["comexampleext"] != "value" {
+			t.Errorf("[%s] invalid extensions: %v", test.name, got.Extensions)
+		}
+		if !bytes.Equal(got.Data, test.data) {
+			t.Errorf("[%s] invalid data: %s", test.name, got.Data)
+		}
+	}
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"no-specversion", `{"id":"1","source":"/","type":"t"}`},
+		{"unknown-specversion", `{"specversion":"0.3","id":"1","source":"/","type":"t"}`},
+		{"no-id", `{"specversion":"1.0","source":"/","type":"t"}`},
+		{"no-source", `{"specversion":"1.0","id":"1","type":"t"}`},
+		{"no-type", `{"specversion":"1.0","id":"1","source":"/"}`},
+		{"invalid-time", `{"specversion":"1.0","id":"1","source":"/","type":"t","time":"now"}`},
+		{"both-data", `{"specversion":"1.0","id":"1","source":"/","type":"t","data":{},"data_base64":""}`},
+	}
+
+	for _, test := range tests {
+		var ev Event
+		err := json.Unmarshal([]byte(test.json), &ev)
+		if err == nil {
+			t.Errorf("[%s] expected error", test.name)
+		}
+	}
+}
+
+func TestValidExtensionName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"repository", true},
+		{"ext1", true},
+		{"", false},
+		{"Repository", false},
+		{"repo_name", false},
+		{"repo-name", false},
+	}
+
+	for _, test := range tests {
+		if ValidExtensionName(test.name) != test.valid {
+			t.Errorf("[%s] unexpected result: %v", test.name, !test.valid)
+		}
+	}
+}
@@ -1,36 +1,107 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 type Config struct {
-	Listen        string        `json:"listen"`
-	TLS           *TLSConfig    `json:"tls"`
-	GitHub        *GitHubConfig `json:"github"`
-	DockerHub     *ProxyConfig  `json:"dockerhub"`
-	Alertmanager  *ProxyConfig  `json:"alertmanager"`
-	AnchoreEngine *ProxyConfig  `json:"anchore-engine"`
-	Clair         *ProxyConfig  `json:"clair"`
-	Slack         *ProxyConfig  `json:"slack"`
+	Listen  string         `json:"listen" yaml:"listen"`
+	TLS     *TLSConfig     `json:"tls" yaml:"tls"`
+	Metrics *MetricsConfig `json:"metrics" yaml:"metrics"`
+	Routes  []*RouteConfig `json:"routes" yaml:"routes"`
+
+	// Per-service configurations. These are kept for compatibility
+	// and converted to routes by AllRoutes().
+	GitHub        *GitHubConfig `json:"github" yaml:"github"`
+	DockerHub     *ProxyConfig  `json:"dockerhub" yaml:"dockerhub"`
+	Alertmanager  *ProxyConfig  `json:"alertmanager" yaml:"alertmanager"`
+	AnchoreEngine *ProxyConfig  `json:"anchore-engine" yaml:"anchore-engine"`
+	Clair         *ProxyConfig  `json:"clair" yaml:"clair"`
+	Slack         *SlackConfig  `json:"slack" yaml:"slack"`
 }
 
 type TLSConfig struct {
-	CertFile string `json:"certFile"`
-	KeyFile  string `json:"keyFile"`
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+}
+
+type MetricsConfig struct {
+	Listen string `json:"listen" yaml:"listen"`
+	Path   string `json:"path" yaml:"path"`
+}
+
+type RouteConfig struct {
+	Name       string                 `json:"name" yaml:"name"`
+	Type       string                 `json:"type" yaml:"type"`
+	Path       string                 `json:"path" yaml:"path"`
+	Backend    string                 `json:"backend" yaml:"backend"`
+	Backends   []*BackendConfig       `json:"backends" yaml:"backends"`
+	Policy     string                 `json:"policy" yaml:"policy"`
+	Mode       string                 `json:"mode" yaml:"mode"`
+	Retry      *RetryConfig           `json:"retry" yaml:"retry"`
+	Async      *AsyncConfig           `json:"async" yaml:"async"`
+	DeadLetter *DeadLetterConfig      `json:"deadLetter" yaml:"deadLetter"`
+	Options    map[string]interface{} `json:"options" yaml:"options"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `json:"maxAttempts" yaml:"maxAttempts"`
+	InitialBackoff time.Duration `json:"initialBackoff" yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+	Jitter         float64       `json:"jitter" yaml:"jitter"`
+	StatusCodes    []int         `json:"statusCodes" yaml:"statusCodes"`
+}
+
+type AsyncConfig struct {
+	Dir     string `json:"dir" yaml:"dir"`
+	Workers int    `json:"workers" yaml:"workers"`
+}
+
+type DeadLetterConfig struct {
+	Dir string `json:"dir" yaml:"dir"`
+	URL string `json:"url" yaml:"url"`
+}
+
+type BackendConfig struct {
+	Name    string            `json:"name" yaml:"name"`
+	URL     string            `json:"url" yaml:"url"`
+	Primary bool              `json:"primary" yaml:"primary"`
+	Match   map[string]string `json:"match" yaml:"match"`
 }
 
 type GitHubConfig struct {
-	Path    string `json:"path"`
-	Backend string `json:"backend"`
-	Secret  string `json:"secret"`
+	Path        string   `json:"path" yaml:"path"`
+	Backend     string   `json:"backend" yaml:"backend"`
+	Secret      string   `json:"secret" yaml:"secret"`
+	Secrets     []string `json:"secrets" yaml:"secrets"`
+	SecretFiles []string `json:"secretFiles" yaml:"secretFiles"`
+	SecretEnvs  []string `json:"secretEnvs" yaml:"secretEnvs"`
+	Mode        string   `json:"mode" yaml:"mode"`
+}
+
+type SlackConfig struct {
+	Path     string `json:"path" yaml:"path"`
+	Backend  string `json:"backend" yaml:"backend"`
+	Secret   string `json:"secret" yaml:"secret"`
+	Insecure bool   `json:"insecure" yaml:"insecure"`
+	Mode     string `json:"mode" yaml:"mode"`
 }
 
 type ProxyConfig struct {
-	Path    string `json:"path"`
-	Backend string `json:"backend"`
+	Path    string `json:"path" yaml:"path"`
+	Backend string `json:"backend" yaml:"backend"`
+	Mode    string `json:"mode" yaml:"mode"`
 }
 
 func New() *Config {
 	return &Config{
 		Listen: "0.0.0.0:24381",
 		TLS:    &TLSConfig{},
+		Metrics: &MetricsConfig{
+			Path: "/metrics",
+		},
 		GitHub: &GitHubConfig{
 			Path: "/github",
 		},
@@ -46,8 +117,189 @@ func New() *Config {
 		Clair: &ProxyConfig{
 			Path: "/clair",
 		},
-		Slack: &ProxyConfig{
+		Slack: &SlackConfig{
 			Path: "/slack",
 		},
 	}
 }
+
+// UnmarshalYAML also accepts the keys of the previous versions, which
+// were matched by the lowercased field names.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	err := unmarshal((*plain)(c))
+	if err != nil {
+		return err
+	}
+
+	legacy := struct {
+		AnchoreEngine *ProxyConfig `yaml:"anchoreengine"`
+	}{c.AnchoreEngine}
+
+	err = unmarshal(&legacy)
+	if err != nil {
+		return err
+	}
+	c.AnchoreEngine = legacy.AnchoreEngine
+
+	return nil
+}
+
+// UnmarshalYAML also accepts the keys of the previous versions, which
+// were matched by the lowercased field names.
+func (c *TLSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TLSConfig
+	err := unmarshal((*plain)(c))
+	if err != nil {
+		return err
+	}
+
+	var legacy struct {
+		CertFile string `yaml:"certfile"`
+		KeyFile  string `yaml:"keyfile"`
+	}
+
+	err = unmarshal(&legacy)
+	if err != nil {
+		return err
+	}
+	if c.CertFile == "" {
+		c.CertFile = legacy.CertFile
+	}
+	if c.KeyFile == "" {
+		c.KeyFile = legacy.KeyFile
+	}
+
+	return nil
+}
+
+// AllRoutes returns the routes in the configuration followed by
+// the routes converted from per-service configurations. Per-service
+// configurations without backend are skipped.
+func (c *Config) AllRoutes() ([]*RouteConfig, error) {
+	routes := []*RouteConfig{}
+	paths := map[string]bool{}
+
+	add := func(r *RouteConfig) error {
+		if r.Type == "" {
+			return errors.New("route type must be specified")
+		}
+		if r.Path == "" {
+			r.Path = fmt.Sprintf("/%s", r.Type)
+		}
+		if r.Name == "" {
+			r.Name = r.Path
+		}
+		if r.Backend != "" {
+			b := &BackendConfig{URL: r.Backend}
+			r.Backends = append([]*BackendConfig{b}, r.Backends...)
+			r.Backend = ""
+		}
+		if len(r.Backends) == 0 {
+			return fmt.Errorf("backend must be specified: %s", r.Name)
+		}
+		// Queued and dead-lettered events refer to the backend by name.
+		names := map[string]bool{}
+		for _, b := range r.Backends {
+			if b.URL == "" {
+				return fmt.Errorf("backend URL must be specified: %s", r.Name)
+			}
+			if b.Name == "" {
+				b.Name = b.URL
+			}
+			if names[b.Name] {
+				return fmt.Errorf("duplicate backend name: %s: %s", r.Name, b.Name)
+			}
+			names[b.Name] = true
+		}
+		if r.Async != nil && r.Async.Dir == "" {
+			return fmt.Errorf("queue directory must be specified: %s", r.Name)
+		}
+		// Failures of synchronous delivery are returned to the sender,
+		// which redelivers the webhook, so only queued events are
+		// dead-lettered.
+		if r.DeadLetter != nil && r.Async == nil {
+			return fmt.Errorf("dead letter requires async delivery: %s", r.Name)
+		}
+		if r.DeadLetter != nil && (r.DeadLetter.Dir == "") == (r.DeadLetter.URL == "") {
+			return fmt.Errorf("either dead letter directory or URL must be specified: %s", r.Name)
+		}
+		if paths[r.Path] {
+			return fmt.Errorf("duplicate route path: %s", r.Path)
+		}
+
+		paths[r.Path] = true
+		routes = append(routes, r)
+
+		return nil
+	}
+
+	for _, r := range c.Routes {
+		err := add(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.GitHub != nil && c.GitHub.Backend != "" {
+		err := add(&RouteConfig{
+			Type:    "github",
+			Path:    c.GitHub.Path,
+			Backend: c.GitHub.Backend,
+			Mode:    c.GitHub.Mode,
+			Options: map[string]interface{}{
+				"secret":      c.GitHub.Secret,
+				"secrets":     c.GitHub.Secrets,
+				"secretFiles": c.GitHub.SecretFiles,
+				"secretEnvs":  c.GitHub.SecretEnvs,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	legacy := []struct {
+		name string
+		pc   *ProxyConfig
+	}{
+		{"dockerhub", c.DockerHub},
+		{"alertmanager", c.Alertmanager},
+		{"anchore-engine", c.AnchoreEngine},
+		{"clair", c.Clair},
+	}
+
+	for _, l := range legacy {
+		if l.pc == nil || l.pc.Backend == "" {
+			continue
+		}
+
+		err := add(&RouteConfig{
+			Type:    l.name,
+			Path:    l.pc.Path,
+			Backend: l.pc.Backend,
+			Mode:    l.pc.Mode,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Slack != nil && c.Slack.Backend != "" {
+		err := add(&RouteConfig{
+			Type:    "slack",
+			Path:    c.Slack.Path,
+			Backend: c.Slack.Backend,
+			Mode:    c.Slack.Mode,
+			Options: map[string]interface{}{
+				"secret":   c.Slack.Secret,
+				"insecure": c.Slack.Insecure,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func loadConfig(t *testing.T, buf string) *Config {
+	c := New()
+	err := yaml.Unmarshal([]byte(buf), &c)
+	if err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	return c
+}
+
+func TestAllRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		routes []string
+	}{
+		{
+			"routes",
+			`
+routes:
+- type: github
+  backend: http://127.0.0.1:3000
+- name: org2
+  type: github
+  path: /github/org2
+  backend: http://127.0.0.1:3001
+  backends:
+  - name: audit
+    url: http://127.0.0.1:3002
+`,
+			[]string{
+				"/github github /github [http://127.0.0.1:3000]",
+				"org2 github /github/org2 [http://127.0.0.1:3001 audit]",
+			},
+		},
+		{
+			"legacy",
+			`
+github:
+  backend: http://127.0.0.1:3000
+  secret: test
+dockerhub:
+  path: /hub
+  backend: http://127.0.0.1:3001
+anchore-engine:
+  backend: http://127.0.0.1:3002
+  mode: structured
+clair:
+  path: /unused
+`,
+			[]string{
+				"/github github /github [http://127.0.0.1:3000]",
+				"/hub dockerhub /hub [http://127.0.0.1:3001]",
+				"/anchore-engine anchore-engine /anchore-engine [http://127.0.0.1:3002]",
+			},
+		},
+		{
+			"mixed",
+			`
+routes:
+- type: slack
+  path: /slack/app1
+  backend: http://127.0.0.1:3000
+slack:
+  backend: http://127.0.0.1:3001
+`,
+			[]string{
+				"/slack/app1 slack /slack/app1 [http://127.0.0.1:3000]",
+				"/slack slack /slack [http://127.0.0.1:3001]",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c := loadConfig(t, test.config)
+
+		routes, err := c.AllRoutes()
+		if err != nil {
+			t.Fatalf("[%s] routes error: %v", test.name, err)
+		}
+
+		actual := []string{}
+		for _, r := range routes {
+			names := []string{}
+			for _, b := range r.Backends {
+				names = append(names, b.Name)
+			}
+			if r.Backend != "" {
+				t.Errorf("[%s] backend must be merged into backends: %s", test.name, r.Backend)
+			}
+			actual = append(actual, fmt.Sprintf("%s %s %s %v", r.Name, r.Type, r.Path, names))
+		}
+
+		if fmt.Sprint(actual) != fmt.Sprint(test.routes) {
+			t.Errorf("[%s] invalid routes: %v", test.name, actual)
+		}
+	}
+}
+
+func TestAllRoutesLegacy(t *testing.T) {
+	c := loadConfig(t, `
+github:
+  backend: http://127.0.0.1:3000
+  secret: test
+  secrets: [old]
+anchore-engine:
+  backend: http://127.0.0.1:3002
+  mode: structured
+slack:
+  backend: http://127.0.0.1:3003
+  secret: signing
+`)
+
+	routes, err := c.AllRoutes()
+	if err != nil {
+		t.Fatalf("routes error: %v", err)
+	}
+
+	if len(routes) != 3 {
+		t.Fatalf("invalid number of routes: %d", len(routes))
+	}
+	if routes[0].Options["secret"] != "test" || fmt.Sprint(routes[0].Options["secrets"]) != "[old]" {
+		t.Errorf("invalid options: %v", routes[0].Options)
+	}
+	if routes[1].Mode != "structured" {
+		t.Errorf("invalid mode: %v", routes[1].Mode)
+	}
+	if routes[2].Options["secret"] != "signing" || routes[2].Options["insecure"] != false {
+		t.Errorf("invalid options: %v", routes[2].Options)
+	}
+
+	// The keys of the previous versions are matched by the lowercased
+	// field names.
+	c = loadConfig(t, `
+tls:
+  certfile: /etc/tls/cert.pem
+  keyfile: /etc/tls/key.pem
+anchoreengine:
+  backend: http://127.0.0.1:3002
+`)
+
+	if c.TLS.CertFile != "/etc/tls/cert.pem" || c.TLS.KeyFile != "/etc/tls/key.pem" {
+		t.Errorf("invalid TLS config: %v", c.TLS)
+	}
+
+	routes, err = c.AllRoutes()
+	if err != nil {
+		t.Fatalf("routes error: %v", err)
+	}
+
+	if len(routes) != 1 {
+		t.Fatalf("invalid number of routes: %d", len(routes))
+	}
+	if routes[0].Type != "anchore-engine" || routes[0].Path != "/anchore-engine" {
+		t.Errorf("invalid route: %s %s", routes[0].Type, routes[0].Path)
+	}
+}
+
+func TestAllRoutesError(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			"duplicate-path",
+			`
+routes:
+- type: github
+  backend: http://127.0.0.1:3000
+github:
+  backend: http://127.0.0.1:3001
+`,
+		},
+		{
+			"no-type",
+			`
+routes:
+- path: /github
+  backend: http://127.0.0.1:3000
+`,
+		},
+		{
+			"no-backend",
+			`
+routes:
+- type: github
+`,
+		},
+		{
+			"no-backend-url",
+			`
+routes:
+- type: github
+  backends:
+  - name: ci
+`,
+		},
+		{
+			"duplicate-backend-name",
+			`
+routes:
+- type: github
+  backends:
+  - name: ci
+    url: http://127.0.0.1:3000
+  - name: ci
+    url: http://127.0.0.1:3001
+`,
+		},
+		{
+			"duplicate-backend-url",
+			`
+routes:
+- type: github
+  backend: http://127.0.0.1:3000
+  backends:
+  - url: http://127.0.0.1:3000
+    match:
+      type: com.github.push
+`,
+		},
+		{
+			"no-queue-dir",
+			`
+routes:
+- type: github
+  backend: http://127.0.0.1:3000
+  async:
+    workers: 2
+`,
+		},
+		{
+			"dead-letter-without-async",
+			`
+routes:
+- type: github
+  backend: http://127.0.0.1:3000
+  deadLetter:
+    dir: /tmp
+`,
+		},
+		{
+			"dead-letter-dir-and-url",
+			`
+routes:
+- type: github
+  backend: http://127.0.0.1:3000
+  async:
+    dir: /tmp
+  deadLetter:
+    dir: /tmp
+    url: http://127.0.0.1:3010
+`,
+		},
+	}
+
+	for _, test := range tests {
+		c := loadConfig(t, test.config)
+
+		_, err := c.AllRoutes()
+		if err == nil {
+			t.Errorf("[%s] config must be rejected", test.name)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Package deadletter implements the destinations of events that could
+// not be delivered to the backend.
+//
+// Dead-lettered events are stored in the JSON format of CloudEvents
+// and the failure is recorded with the following extension attributes.
+//
+//   - deadletterroute: Name of the route
+//   - deadletterbackend: Name of the backend
+//   - deadletterstatus: Last status code from the backend, if any
+//   - deadlettererror: Last error of the delivery, if any
+//   - deadletterattempts: Number of delivery attempts
+//   - deadlettertime: Time of the failure in RFC3339 format
+//   - deadletterheader: Headers of the webhook request forwarded to the
+//     backend in JSON format, if any
+//
+// The data of the event is always stored in data_base64, so that the
+// event can be delivered again byte-for-byte.
+package deadletter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+)
+
+const (
+	ExtRoute    = "deadletterroute"
+	ExtBackend  = "deadletterbackend"
+	ExtStatus   = "deadletterstatus"
+	ExtError    = "deadlettererror"
+	ExtAttempts = "deadletterattempts"
+	ExtTime     = "deadlettertime"
+	ExtHeader   = "deadletterheader"
+
+	fileExt = ".json"
+)
+
+// Failure is the metadata of the failed delivery.
+type Failure struct {
+	Route    string
+	Backend  string
+	Status   int
+	Error    string
+	Attempts int
+	Time     time.Time
+	Header   http.Header
+}
+
+// Sink is the destination of dead-lettered events.
+type Sink interface {
+	Send(ce *cloudevents.Event, f *Failure) error
+}
+
+// Annotate returns a copy of the event with extension attributes of
+// the failure.
+func Annotate(ce *cloudevents.Event, f *Failure) *cloudevents.Event {
+	ev := *ce
+	ev.Extensions = map[string]string{}
+	for name, value := range ce.Extensions {
+		ev.Extensions[name] = value
+	}
+
+	ev.SetExtension(ExtRoute, f.Route)
+	ev.SetExtension(ExtBackend, f.Backend)
+	if f.Status != 0 {
+		ev.SetExtension(ExtStatus, strconv.Itoa(f.Status))
+	}
+	ev.SetExtension(ExtError, f.Error)
+	ev.SetExtension(ExtAttempts, strconv.Itoa(f.Attempts))
+	ev.SetExtension(ExtTime, f.Time.UTC().Format(time.RFC3339))
+	if len(f.Header) > 0 {
+		// Headers are valid JSON, so the error can be ignored.
+		buf, _ := json.Marshal(f.Header)
+		ev.SetExtension(ExtHeader, string(buf))
+	}
+
+	return &ev
+}
+
+// Strip returns a copy of the event without extension attributes of
+// the failure, and the failure.
+func Strip(ce *cloudevents.Event) (*cloudevents.Event, *Failure) {
+	f := &Failure{
+		Route:   ce.Extensions[ExtRoute],
+		Backend: ce.Extensions[ExtBackend],
+		Error:   ce.Extensions[ExtError],
+	}
+
+	f.Status, _ = strconv.Atoi(ce.Extensions[ExtStatus])
+	f.Attempts, _ = strconv.Atoi(ce.Extensions[ExtAttempts])
+	f.Time, _ = time.Parse(time.RFC3339, ce.Extensions[ExtTime])
+	if v := ce.Extensions[ExtHeader]; v != "" {
+		json.Unmarshal([]byte(v), &f.Header)
+	}
+
+	ev := *ce
+	ev.Extensions = nil
+	for name, value := range ce.Extensions {
+		switch name {
+		case ExtRoute, ExtBackend, ExtStatus, ExtError, ExtAttempts, ExtTime, ExtHeader:
+			continue
+		}
+		ev.SetExtension(name, value)
+	}
+
+	return &ev, f
+}
+
+// encode returns the event annotated with the failure in the JSON
+// format of CloudEvents. The data is embedded as base64 string even if
+// it is JSON, since JSON data is re-encoded by the JSON format.
+func encode(ce *cloudevents.Event, f *Failure) ([]byte, error) {
+	ev := Annotate(ce, f)
+	data := ev.Data
+	ev.Data = nil
+
+	buf, err := json.Marshal(ev)
+	if err != nil || len(data) == 0 {
+		return buf, err
+	}
+
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(buf, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	m["data_base64"], err = json.Marshal(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(m)
+}
+
+// DirSink writes dead-lettered events to files in the directory.
+type DirSink struct {
+	dir string
+	seq uint64
+}
+
+// NewDirSink returns a new sink that writes to the directory. The
+// directory is created if it does not exist.
+func NewDirSink(dir string) (*DirSink, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirSink{dir: dir}, nil
+}
+
+func (s *DirSink) Send(ce *cloudevents.Event, f *Failure) error {
+	buf, err := encode(ce, f)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%06d%s", f.Time.UnixNano(), atomic.AddUint64(&s.seq, 1), fileExt)
+	tmp := filepath.Join(s.dir, fmt.Sprintf(".%s.tmp", name))
+
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// HTTPSink sends dead-lettered events to the URL in structured content
+// mode.
+type HTTPSink struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// NewHTTPSink returns a new sink that sends to the URL.
+func NewHTTPSink(u *url.URL) *HTTPSink {
+	return &HTTPSink{
+		url:    u,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *HTTPSink) Send(ce *cloudevents.Event, f *Failure) error {
+	buf, err := encode(ce, f)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url.String(), cloudevents.StructuredContentType, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ReadFile reads the dead-lettered event from the file.
+func ReadFile(p string) (*cloudevents.Event, *Failure, error) {
+	buf, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ce cloudevents.Event
+	err = json.Unmarshal(buf, &ce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ce.Extensions[ExtRoute] == "" {
+		return nil, nil, errors.New("not a dead-lettered event")
+	}
+
+	ev, f := Strip(&ce)
+
+	return ev, f, nil
+}
+
+// Files returns the list of dead-lettered event files in the directory.
+func Files(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, f.Name()))
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,115 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+)
+
+func newEvent() *cloudevents.Event {
+	s, _ := url.Parse("/test")
+
+	return &cloudevents.Event{
+		ID:              "1",
+		Type:            "com.example.test",
+		Source:          *s,
+		DataContentType: "application/json",
+		Extensions:      map[string]string{"repository": "test"},
+		Data:            []byte("{\n  \"foo\": \"bar\"\n}\n"),
+	}
+}
+
+func newFailure() *Failure {
+	return &Failure{
+		Route:    "github",
+		Backend:  "ci",
+		Status:   503,
+		Error:    "unexpected status",
+		Attempts: 3,
+		Time:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Header:   http.Header{"X-Hub-Signature": []string{"sha1=test"}},
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewDirSink(dir)
+	if err != nil {
+		t.Fatalf("sink error: %v", err)
+	}
+
+	ce := newEvent()
+	err = sink.Send(ce, newFailure())
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	if len(ce.Extensions) != 1 {
+		t.Errorf("original event must not be modified: %v", ce.Extensions)
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("files error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("invalid number of files: %d", len(files))
+	}
+
+	got, f, err := ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	if got.ID != ce.ID || string(got.Data) != string(ce.Data) {
+		t.Errorf("invalid event: %v", got)
+	}
+	if len(got.Extensions) != 1 || got.Extensions["repository"] != "test" {
+		t.Errorf("invalid extensions: %v", got.Extensions)
+	}
+	if !reflect.DeepEqual(f, newFailure()) {
+		t.Errorf("invalid failure: %v", f)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got cloudevents.Event
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != cloudevents.StructuredContentType {
+			t.Errorf("invalid content type: %s", req.Header.Get("Content-Type"))
+		}
+
+		err := json.NewDecoder(req.Body).Decode(&got)
+		if err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	err := NewHTTPSink(u).Send(newEvent(), newFailure())
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	if string(got.Data) != string(newEvent().Data) {
+		t.Errorf("invalid data: %q", got.Data)
+	}
+	if got.Extensions[ExtStatus] != "503" || got.Extensions[ExtAttempts] != "3" || got.Extensions[ExtBackend] != "ci" {
+		t.Errorf("invalid extensions: %v", got.Extensions)
+	}
+}
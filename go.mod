@@ -5,6 +5,8 @@ go 1.13
 require (
 	github.com/google/go-github/v29 v29.0.2
 	github.com/prometheus/alertmanager v0.20.0
+	github.com/prometheus/client_golang v1.2.1
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4
 	github.com/satori/go.uuid v1.2.0
 	github.com/spf13/cobra v0.0.5
 	gopkg.in/yaml.v2 v2.2.8
@@ -1,32 +1,37 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	uuid "github.com/satori/go.uuid"
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/spf13/cobra"
 	"github.com/summerwind/cloudevents-webhook-gateway/config"
+	"github.com/summerwind/cloudevents-webhook-gateway/deadletter"
+	"github.com/summerwind/cloudevents-webhook-gateway/metrics"
 	"github.com/summerwind/cloudevents-webhook-gateway/proxy"
+	"github.com/summerwind/cloudevents-webhook-gateway/queue"
 	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
-	"github.com/summerwind/cloudevents-webhook-gateway/webhook/alertmanager"
-	"github.com/summerwind/cloudevents-webhook-gateway/webhook/anchoreengine"
-	"github.com/summerwind/cloudevents-webhook-gateway/webhook/clair"
-	"github.com/summerwind/cloudevents-webhook-gateway/webhook/dockerhub"
-	"github.com/summerwind/cloudevents-webhook-gateway/webhook/github"
-	"github.com/summerwind/cloudevents-webhook-gateway/webhook/slack"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/alertmanager"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/anchoreengine"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/bitbucket"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/clair"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/dockerhub"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/gitea"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/github"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/gitlab"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/harbor"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/quay"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/registry"
+	_ "github.com/summerwind/cloudevents-webhook-gateway/webhook/slack"
 )
 
 var (
@@ -51,80 +56,101 @@ func loadConfig(configPath string) (*config.Config, error) {
 	return c, nil
 }
 
-func newProxyHandler(backend *url.URL, parser webhook.Parser) (*httputil.ReverseProxy, error) {
-	director := func(req *http.Request) {
-		// Copy request body
-		body := req.Body
-		if body != nil && body != http.NoBody {
-			var buf bytes.Buffer
-
-			_, err := buf.ReadFrom(body)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "unable to read request body: %s\n", err)
-				return
-			}
-
-			err = body.Close()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %s\n", err)
-				return
-			}
+// route is the webhook endpoint built from the route configuration.
+type route struct {
+	config  *config.RouteConfig
+	handler *proxy.Handler
+	queue   *queue.Queue
+}
 
-			body = ioutil.NopCloser(&buf)
-			req.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
-		}
+// newRoutes builds the webhook endpoints from the configuration.
+func newRoutes(c *config.Config) ([]*route, error) {
+	rcs, err := c.AllRoutes()
+	if err != nil {
+		return nil, err
+	}
 
-		ce, err := parser.Parse(req)
+	routes := []*route{}
+	for _, rc := range rcs {
+		r, err := newRoute(rc)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "parse error: %s\n", err)
-			return
+			return nil, fmt.Errorf("%s: %s", rc.Name, err)
 		}
+		routes = append(routes, r)
+	}
 
-		if ce.ID == "" {
-			id := uuid.NewV4()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "unable to generate event ID: %s\n", err)
-				return
-			}
-			ce.ID = id.String()
-		}
+	return routes, nil
+}
 
-		if ce.Time == nil {
-			t := time.Now()
-			ce.Time = &t
-		}
+func newRoute(rc *config.RouteConfig) (*route, error) {
+	parser, err := webhook.NewParser(rc.Type, webhook.Options(rc.Options))
+	if err != nil {
+		return nil, err
+	}
 
-		req.Body = body
+	backends := []*proxy.Backend{}
+	for _, b := range rc.Backends {
+		u, err := url.Parse(b.URL)
+		if err != nil {
+			return nil, err
+		}
 
-		req.Host = backend.Host
-		req.URL.Scheme = backend.Scheme
-		req.URL.Host = backend.Host
-		req.URL.Path = backend.Path
+		backends = append(backends, &proxy.Backend{
+			Name:    b.Name,
+			URL:     u,
+			Primary: b.Primary,
+			Match:   b.Match,
+		})
+	}
 
-		req.Header.Set("ce-specversion", "1.0")
-		req.Header.Set("ce-type", ce.Type)
-		req.Header.Set("ce-source", ce.Source.String())
-		req.Header.Set("ce-id", ce.ID)
+	retry := proxy.RetryConfig{}
+	if rc.Retry != nil {
+		retry.MaxAttempts = rc.Retry.MaxAttempts
+		retry.InitialBackoff = rc.Retry.InitialBackoff
+		retry.MaxBackoff = rc.Retry.MaxBackoff
+		retry.Jitter = rc.Retry.Jitter
+		retry.StatusCodes = rc.Retry.StatusCodes
+	}
 
-		if ce.Subject != "" {
-			req.Header.Set("ce-subject", ce.Subject)
-		}
-		if ce.Time != nil {
-			req.Header.Set("ce-time", ce.Time.Format(time.RFC3339))
-		}
-		if ce.DataSchema.String() != "" {
-			req.Header.Set("ce-dataschema", ce.DataSchema.String())
-		}
-		if ce.DataContentType != "" {
-			req.Header.Set("Content-Type", ce.DataContentType)
+	var q *queue.Queue
+	if rc.Async != nil {
+		q, err = queue.New(rc.Async.Dir)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		log.Printf("remote_addr:%s event_id:%s event_type:%s source:%s", req.RemoteAddr, ce.ID, ce.Type, ce.Source.String())
+	var sink deadletter.Sink
+	if rc.DeadLetter != nil {
+		if rc.DeadLetter.Dir != "" {
+			sink, err = deadletter.NewDirSink(rc.DeadLetter.Dir)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			u, err := url.Parse(rc.DeadLetter.URL)
+			if err != nil {
+				return nil, err
+			}
+			sink = deadletter.NewHTTPSink(u)
+		}
 	}
 
-	transport := proxy.NewTransport()
+	handler, err := proxy.NewHandler(proxy.HandlerConfig{
+		Name:       rc.Name,
+		Parser:     parser,
+		Backends:   backends,
+		Mode:       rc.Mode,
+		Policy:     rc.Policy,
+		Retry:      retry,
+		Queue:      q,
+		DeadLetter: sink,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return &httputil.ReverseProxy{Director: director, Transport: transport}, nil
+	return &route{config: rc, handler: handler, queue: q}, nil
 }
 
 // run starts the HTTP server to process authentication.
@@ -149,100 +175,42 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	mux := http.NewServeMux()
-	if c.GitHub.Backend != "" {
-		backend, err := url.Parse(c.GitHub.Backend)
-		if err != nil {
-			return err
-		}
-		parser := github.NewParser(c.GitHub.Secret)
-
-		handler, err := newProxyHandler(backend, parser)
-		if err != nil {
-			return err
-		}
-
-		mux.Handle(c.GitHub.Path, handler)
-	}
-
-	if c.DockerHub.Backend != "" {
-		backend, err := url.Parse(c.DockerHub.Backend)
-		if err != nil {
-			return err
-		}
-		parser := dockerhub.NewParser()
-
-		handler, err := newProxyHandler(backend, parser)
-		if err != nil {
-			return err
-		}
-
-		mux.Handle(c.DockerHub.Path, handler)
-	}
-
-	if c.Alertmanager.Backend != "" {
-		backend, err := url.Parse(c.Alertmanager.Backend)
-		if err != nil {
-			return err
-		}
-		parser := alertmanager.NewParser()
-
-		handler, err := newProxyHandler(backend, parser)
-		if err != nil {
-			return err
-		}
-
-		mux.Handle(c.Alertmanager.Path, handler)
+	routes, err := newRoutes(c)
+	if err != nil {
+		return err
 	}
 
-	if c.AnchoreEngine.Backend != "" {
-		backend, err := url.Parse(c.AnchoreEngine.Backend)
-		if err != nil {
-			return err
-		}
-		parser := anchoreengine.NewParser()
-
-		handler, err := newProxyHandler(backend, parser)
-		if err != nil {
-			return err
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		if r.queue != nil {
+			err = r.queue.Start(r.config.Async.Workers, r.handler.Deliver)
+			if err != nil {
+				return fmt.Errorf("%s: %s", r.config.Name, err)
+			}
+			defer r.queue.Stop()
 		}
 
-		mux.Handle(c.AnchoreEngine.Path, handler)
+		mux.Handle(r.config.Path, r.handler)
 	}
 
-	if c.Clair.Backend != "" {
-		backend, err := url.Parse(c.Clair.Backend)
-		if err != nil {
-			return err
-		}
-		parser := clair.NewParser()
-
-		handler, err := newProxyHandler(backend, parser)
-		if err != nil {
-			return err
-		}
-
-		mux.Handle(c.Clair.Path, handler)
+	server := &http.Server{
+		Addr:    c.Listen,
+		Handler: mux,
 	}
 
-	if c.Slack.Backend != "" {
-		backend, err := url.Parse(c.Slack.Backend)
-		if err != nil {
-			return err
-		}
-		parser := slack.NewParser()
+	var metricsServer *http.Server
+	if c.Metrics.Listen != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(c.Metrics.Path, metrics.Handler())
 
-		handler, err := newProxyHandler(backend, parser)
-		if err != nil {
-			return err
+		metricsServer = &http.Server{
+			Addr:    c.Metrics.Listen,
+			Handler: metricsMux,
 		}
 
-		mux.Handle(c.Slack.Path, handler)
-	}
-
-	server := &http.Server{
-		Addr:    c.Listen,
-		Handler: mux,
+		go func() {
+			metricsServer.ListenAndServe()
+		}()
 	}
 
 	go func() {
@@ -265,6 +233,13 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if metricsServer != nil {
+		err = metricsServer.Shutdown(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -281,6 +256,8 @@ func main() {
 	cmd.Flags().StringP("config", "c", "config.yml", "Path to the configuration file")
 	cmd.Flags().BoolP("version", "v", false, "Display version information and exit")
 
+	cmd.AddCommand(newRedriveCommand())
+
 	err := cmd.Execute()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
@@ -0,0 +1,77 @@
+// Package metrics provides the Prometheus metrics of the gateway.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cloudevents_webhook_gateway"
+
+var (
+	// Requests is the number of webhook requests received. The type
+	// label is the type of the first event of the request, or empty if
+	// the request could not be converted to events.
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of webhook requests received.",
+	}, []string{"route", "type"})
+
+	// ParseFailures is the number of webhook requests that could not
+	// be parsed.
+	ParseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "parse_failures_total",
+		Help:      "Number of webhook requests that could not be parsed.",
+	}, []string{"route"})
+
+	// SignatureFailures is the number of webhook requests with invalid
+	// signature.
+	SignatureFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signature_failures_total",
+		Help:      "Number of webhook requests with invalid signature.",
+	}, []string{"route"})
+
+	// Forwarded is the number of events delivered to the backends.
+	Forwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_forwarded_total",
+		Help:      "Number of events delivered to the backends.",
+	}, []string{"route", "type"})
+
+	// BackendResponses is the number of responses from the backends.
+	// The code label is "error" if the request failed without response.
+	BackendResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_responses_total",
+		Help:      "Number of responses from the backends by status code.",
+	}, []string{"route", "backend", "code"})
+
+	// BackendDuration is the latency of requests to the backends.
+	BackendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Latency of requests to the backends.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "backend"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		Requests,
+		ParseFailures,
+		SignatureFailures,
+		Forwarded,
+		BackendResponses,
+		BackendDuration,
+	)
+}
+
+// Handler returns a HTTP handler that exposes the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
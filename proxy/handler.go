@@ -0,0 +1,636 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/deadletter"
+	"github.com/summerwind/cloudevents-webhook-gateway/metrics"
+	"github.com/summerwind/cloudevents-webhook-gateway/queue"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	// PolicyPrimary returns the response of the primary backend.
+	PolicyPrimary = "primary"
+	// PolicyFirst returns the first successful response of backends.
+	PolicyFirst = "first"
+	// PolicyAll returns the response of the primary backend only if
+	// all backends succeeded.
+	PolicyAll = "all"
+
+	// DefaultAsyncMaxAttempts is the maximum number of attempts of
+	// queued deliveries if it is not configured.
+	DefaultAsyncMaxAttempts = 10
+)
+
+// hopHeaders is the list of hop-by-hop headers that are not forwarded
+// to the backend.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+}
+
+// Backend is the destination of events.
+type Backend struct {
+	Name    string
+	URL     *url.URL
+	Primary bool
+	// Match is the conditions of events sent to the backend. The key
+	// is the name of the attribute of the event, and all values must
+	// be equal to the attributes. All events are sent if it is empty.
+	Match map[string]string
+}
+
+// matches returns true if the event satisfies the conditions of the
+// backend.
+func (b *Backend) matches(ce *cloudevents.Event) bool {
+	for name, value := range b.Match {
+		if attribute(ce, name) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// attribute returns the value of the context attribute or the
+// extension attribute of the event.
+func attribute(ce *cloudevents.Event, name string) string {
+	switch name {
+	case "id":
+		return ce.ID
+	case "type":
+		return ce.Type
+	case "source":
+		return ce.Source.String()
+	case "subject":
+		return ce.Subject
+	case "datacontenttype":
+		return ce.DataContentType
+	}
+
+	return ce.Extensions[name]
+}
+
+type HandlerConfig struct {
+	Name       string
+	Parser     webhook.Parser
+	Backends   []*Backend
+	Mode       string
+	Policy     string
+	Retry      RetryConfig
+	Queue      *queue.Queue
+	DeadLetter deadletter.Sink
+}
+
+// Handler is a HTTP handler that converts webhook requests to
+// CloudEvents and forwards them to the backends.
+type Handler struct {
+	name       string
+	parser     webhook.Parser
+	backends   []*Backend
+	primary    *Backend
+	mode       string
+	policy     string
+	client     *http.Client
+	queue      *queue.Queue
+	deadLetter deadletter.Sink
+}
+
+// result is the result of the delivery to a backend.
+type result struct {
+	backend  *Backend
+	status   int
+	header   http.Header
+	body     []byte
+	err      error
+	attempts int
+}
+
+func (r *result) succeeded() bool {
+	return r.err == nil && r.status >= 200 && r.status < 300
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	// Queued events are retried by default, since the delivery is not
+	// retried by the sender once the request is accepted.
+	if c.Queue != nil && c.Retry.MaxAttempts == 0 {
+		c.Retry.MaxAttempts = DefaultAsyncMaxAttempts
+	}
+
+	transport := NewTransport(c.Retry)
+	transport.route = c.Name
+
+	h := &Handler{
+		name:       c.Name,
+		parser:     c.Parser,
+		backends:   c.Backends,
+		mode:       c.Mode,
+		policy:     c.Policy,
+		client:     &http.Client{Transport: transport},
+		queue:      c.Queue,
+		deadLetter: c.DeadLetter,
+	}
+
+	switch h.mode {
+	case "":
+		h.mode = cloudevents.ModeBinary
+	case cloudevents.ModeBinary, cloudevents.ModeStructured:
+	default:
+		return nil, fmt.Errorf("invalid mode: %s", h.mode)
+	}
+
+	switch h.policy {
+	case "":
+		h.policy = PolicyPrimary
+	case PolicyPrimary, PolicyFirst, PolicyAll:
+	default:
+		return nil, fmt.Errorf("invalid policy: %s", h.policy)
+	}
+
+	if len(h.backends) == 0 {
+		return nil, errors.New("no backend")
+	}
+
+	for _, b := range h.backends {
+		if !b.Primary {
+			continue
+		}
+		if h.primary != nil {
+			return nil, errors.New("multiple primary backends")
+		}
+		h.primary = b
+	}
+
+	if h.primary == nil {
+		h.primary = h.backends[0]
+	}
+
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	events, err := h.parse(req)
+
+	eventType := ""
+	if len(events) > 0 {
+		eventType = events[0].Type
+	}
+	metrics.Requests.WithLabelValues(h.name, eventType).Inc()
+
+	if err != nil {
+		var reply *webhook.Reply
+		if errors.As(err, &reply) {
+			log.Printf("remote_addr:%s reply:%d", req.RemoteAddr, reply.StatusCode)
+			if reply.ContentType != "" {
+				w.Header().Set("Content-Type", reply.ContentType)
+			}
+			w.WriteHeader(reply.StatusCode)
+			w.Write(reply.Body)
+			return
+		}
+
+		// Errors of the webhook request are responded to the sender
+		// without contacting the backends.
+		code := webhook.StatusCode(err)
+		if code == http.StatusNoContent {
+			log.Printf("remote_addr:%s ignored:%s", req.RemoteAddr, err)
+			w.WriteHeader(code)
+			return
+		}
+
+		// Failures of the upstream service are not failures of the
+		// request, and are retried by the sender.
+		switch {
+		case errors.Is(err, webhook.ErrUnauthorized):
+			metrics.SignatureFailures.WithLabelValues(h.name).Inc()
+		case errors.Is(err, webhook.ErrUpstream):
+		default:
+			metrics.ParseFailures.WithLabelValues(h.name).Inc()
+		}
+
+		fmt.Fprintf(os.Stderr, "parse error: %s\n", err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	for _, ce := range events {
+		log.Printf("remote_addr:%s event_id:%s event_type:%s source:%s", req.RemoteAddr, ce.ID, ce.Type, ce.Source.String())
+	}
+
+	header := forwardHeader(req)
+
+	if h.queue != nil {
+		for _, ce := range events {
+			for _, b := range h.backendsFor(ce) {
+				err := h.queue.Put(&queue.Item{Backend: b.Name, Header: header, Event: ce})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "unable to queue event: %s\n", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Deliver all events and respond with the result of the first
+	// failed event, or the result of the last event if all succeeded.
+	var res *result
+	for _, ce := range events {
+		r := h.deliver(ce, header)
+		if res == nil || res.succeeded() {
+			res = r
+		}
+	}
+
+	if res.err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	for key, values := range res.header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	for _, key := range hopHeaders {
+		w.Header().Del(key)
+	}
+
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
+
+// parse reads the webhook request and returns the events.
+func (h *Handler) parse(req *http.Request) ([]*cloudevents.Event, error) {
+	var data []byte
+
+	if req.Body != nil && req.Body != http.NoBody {
+		buf, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, webhook.BadRequest(fmt.Errorf("unable to read request body: %s", err))
+		}
+
+		err = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		data = buf
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	}
+
+	var events []*cloudevents.Event
+	if mp, ok := h.parser.(webhook.MultiParser); ok {
+		all, err := mp.ParseAll(req)
+		if err != nil {
+			return nil, err
+		}
+		events = all
+	} else {
+		ce, err := h.parser.Parse(req)
+		if err != nil {
+			return nil, err
+		}
+		events = []*cloudevents.Event{ce}
+	}
+
+	if len(events) == 0 {
+		return nil, webhook.Ignored(errors.New("no events"))
+	}
+
+	for _, ce := range events {
+		if ce.ID == "" {
+			ce.ID = uuid.NewV4().String()
+		}
+
+		if ce.Time == nil {
+			t := time.Now()
+			ce.Time = &t
+		}
+
+		if ce.Data == nil {
+			ce.Data = data
+		}
+
+		err := ce.Validate()
+		if err != nil {
+			return nil, webhook.BadRequest(fmt.Errorf("invalid event: %s", err))
+		}
+	}
+
+	return events, nil
+}
+
+// deliver sends the event to the backends and returns the result
+// selected by the policy.
+func (h *Handler) deliver(ce *cloudevents.Event, header http.Header) *result {
+	backends := h.backendsFor(ce)
+	if len(backends) == 0 {
+		log.Printf("event_id:%s skipped:no matching backend", ce.ID)
+		return &result{status: http.StatusNoContent}
+	}
+
+	// The first matched backend is used as the primary backend if the
+	// primary backend does not match the event.
+	primaryBackend := backends[0]
+	for _, b := range backends {
+		if b == h.primary {
+			primaryBackend = b
+		}
+	}
+
+	results := make(chan *result, len(backends))
+
+	for _, b := range backends {
+		go func(b *Backend) {
+			results <- h.send(context.Background(), b, ce, header)
+		}(b)
+	}
+
+	var (
+		primary   *result
+		responded *result
+		failed    *result
+	)
+
+	for i := 0; i < len(backends); i++ {
+		res := <-results
+
+		if res.backend == primaryBackend {
+			primary = res
+		}
+		if res.err == nil && responded == nil {
+			responded = res
+		}
+		if !res.succeeded() && failed == nil {
+			failed = res
+		}
+
+		switch h.policy {
+		case PolicyFirst:
+			if res.succeeded() {
+				return res
+			}
+		case PolicyPrimary:
+			if primary != nil {
+				return primary
+			}
+		}
+	}
+
+	switch h.policy {
+	case PolicyFirst:
+		if responded != nil {
+			return responded
+		}
+		return failed
+	case PolicyAll:
+		if failed != nil {
+			if failed.err == nil {
+				return failed
+			}
+			return &result{err: fmt.Errorf("backend %s failed", failed.backend.Name)}
+		}
+	}
+
+	return primary
+}
+
+// backendsFor returns the backends that match the event.
+func (h *Handler) backendsFor(ce *cloudevents.Event) []*Backend {
+	backends := []*Backend{}
+	for _, b := range h.backends {
+		if b.matches(ce) {
+			backends = append(backends, b)
+		}
+	}
+
+	return backends
+}
+
+// Deliver sends the queued item to the backend. The item is sent to
+// the dead-letter sink if the delivery failed. It returns an error if
+// the item has been neither delivered nor dead-lettered, so that the
+// queue keeps the item.
+func (h *Handler) Deliver(ctx context.Context, item *queue.Item) error {
+	return h.deliverItem(ctx, item, true)
+}
+
+// Redrive sends the dead-lettered item to the backend again. It returns
+// an error if the delivery failed.
+func (h *Handler) Redrive(ctx context.Context, item *queue.Item) error {
+	return h.deliverItem(ctx, item, false)
+}
+
+func (h *Handler) deliverItem(ctx context.Context, item *queue.Item, deadLetter bool) error {
+	for _, b := range h.backends {
+		if b.Name != item.Backend {
+			continue
+		}
+
+		res := h.send(ctx, b, item.Event, item.Header)
+		if res.succeeded() {
+			return nil
+		}
+
+		err := res.err
+		if err == nil {
+			err = fmt.Errorf("unexpected status: %d", res.status)
+		}
+
+		if deadLetter && h.deadLetter != nil && ctx.Err() == nil {
+			ferr := h.fail(item.Event, item.Header, res)
+			if ferr == nil {
+				return nil
+			}
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("%w: unknown backend: %s", queue.ErrInvalid, item.Backend)
+}
+
+// fail sends the event that failed to be delivered to the dead-letter
+// sink.
+func (h *Handler) fail(ce *cloudevents.Event, header http.Header, res *result) error {
+	f := &deadletter.Failure{
+		Route:    h.name,
+		Backend:  res.backend.Name,
+		Status:   res.status,
+		Attempts: res.attempts,
+		Time:     time.Now(),
+		Header:   header,
+	}
+	if res.err != nil {
+		f.Error = res.err.Error()
+	}
+
+	err := h.deadLetter.Send(ce, f)
+	if err != nil {
+		log.Printf("event_id:%s backend:%s dead_letter_error:%s", ce.ID, res.backend.Name, err)
+		return err
+	}
+
+	log.Printf("event_id:%s backend:%s dead_lettered:true attempts:%d", ce.ID, res.backend.Name, res.attempts)
+
+	return nil
+}
+
+// send sends the event to the backend.
+func (h *Handler) send(ctx context.Context, b *Backend, ce *cloudevents.Event, header http.Header) *result {
+	res := &result{backend: b}
+
+	ctx = withAttempts(ctx, &res.attempts)
+	ctx = withBackend(ctx, b.Name)
+
+	req, err := NewRequest(ctx, b.URL, ce, h.mode)
+	if err != nil {
+		res.err = err
+		log.Printf("event_id:%s backend:%s error:%s", ce.ID, b.Name, err)
+		return res
+	}
+
+	for key, values := range header {
+		if _, ok := req.Header[key]; ok {
+			continue
+		}
+		req.Header[key] = values
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		res.err = err
+		log.Printf("event_id:%s backend:%s error:%s", ce.ID, b.Name, err)
+		return res
+	}
+	defer resp.Body.Close()
+
+	res.status = resp.StatusCode
+	res.header = resp.Header
+	res.body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		res.err = err
+		log.Printf("event_id:%s backend:%s error:%s", ce.ID, b.Name, err)
+		return res
+	}
+
+	if !res.succeeded() {
+		log.Printf("event_id:%s backend:%s status:%d", ce.ID, b.Name, res.status)
+		return res
+	}
+
+	metrics.Forwarded.WithLabelValues(h.name, ce.Type).Inc()
+
+	return res
+}
+
+// NewRequest returns a new request that sends the event to the
+// specified URL in the specified content mode.
+func NewRequest(ctx context.Context, u *url.URL, ce *cloudevents.Event, mode string) (*http.Request, error) {
+	var body []byte
+
+	header := http.Header{}
+
+	if mode == cloudevents.ModeStructured {
+		buf, err := json.Marshal(ce)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode event: %s", err)
+		}
+
+		body = buf
+		header.Set("Content-Type", cloudevents.StructuredContentType)
+	} else {
+		err := ce.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		body = ce.Data
+
+		header.Set("ce-specversion", cloudevents.SpecVersion)
+		header.Set("ce-type", ce.Type)
+		header.Set("ce-source", ce.Source.String())
+		header.Set("ce-id", ce.ID)
+
+		if ce.Subject != "" {
+			header.Set("ce-subject", ce.Subject)
+		}
+		if ce.Time != nil {
+			header.Set("ce-time", ce.Time.Format(time.RFC3339))
+		}
+		if ce.DataSchema.String() != "" {
+			header.Set("ce-dataschema", ce.DataSchema.String())
+		}
+		if ce.DataContentType != "" {
+			header.Set("Content-Type", ce.DataContentType)
+		}
+
+		for name, value := range ce.Extensions {
+			header.Set(fmt.Sprintf("ce-%s", name), value)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(withEventID(ctx, ce.ID))
+	req.Header = header
+
+	return req, nil
+}
+
+// forwardHeader returns the headers of the webhook request to be
+// forwarded to the backends.
+func forwardHeader(req *http.Request) http.Header {
+	header := http.Header{}
+
+	for key, values := range req.Header {
+		if strings.HasPrefix(strings.ToLower(key), "ce-") {
+			continue
+		}
+		header[key] = values
+	}
+
+	for _, key := range hopHeaders {
+		header.Del(key)
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err == nil {
+		if prior, ok := header["X-Forwarded-For"]; ok {
+			host = strings.Join(prior, ", ") + ", " + host
+		}
+		header.Set("X-Forwarded-For", host)
+	}
+
+	return header
+}
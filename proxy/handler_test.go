@@ -0,0 +1,678 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/deadletter"
+	"github.com/summerwind/cloudevents-webhook-gateway/metrics"
+	"github.com/summerwind/cloudevents-webhook-gateway/queue"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+type testParser struct {
+	err         error
+	contentType string
+}
+
+func (p *testParser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	contentType := p.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	s, _ := url.Parse("/test")
+	ce := &cloudevents.Event{
+		ID:              "1",
+		Type:            "com.example.test",
+		Source:          *s,
+		DataContentType: contentType,
+	}
+
+	return ce, nil
+}
+
+type testMultiParser struct {
+	testParser
+	n int
+}
+
+func (p *testMultiParser) ParseAll(req *http.Request) ([]*cloudevents.Event, error) {
+	events := []*cloudevents.Event{}
+	for i := 0; i < p.n; i++ {
+		ce, err := p.Parse(req)
+		if err != nil {
+			return nil, err
+		}
+		ce.Subject = strconv.Itoa(i)
+		events = append(events, ce)
+	}
+
+	return events, nil
+}
+
+// newBackend returns a test backend that responds with the specified
+// status after the delay.
+func newBackend(t *testing.T, status int, delay time.Duration, count *int32) (*httptest.Server, *Backend) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if count != nil {
+			atomic.AddInt32(count, 1)
+		}
+		if req.Header.Get("ce-id") != "1" {
+			t.Errorf("invalid ce-id: %s", req.Header.Get("ce-id"))
+		}
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		w.Write([]byte(req.Host))
+	}))
+
+	u, _ := url.Parse(ts.URL)
+	return ts, &Backend{Name: ts.URL, URL: u}
+}
+
+func TestHandlerPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		statuses []int
+		delays   []time.Duration
+		primary  int
+		status   int
+		from     int
+	}{
+		{PolicyPrimary, []int{200, 500}, []time.Duration{0, 0}, 0, 200, 0},
+		{PolicyPrimary, []int{200, 500}, []time.Duration{0, 0}, 1, 500, 1},
+		{PolicyFirst, []int{500, 202}, []time.Duration{0, 50 * time.Millisecond}, 0, 202, 1},
+		{PolicyFirst, []int{200, 201}, []time.Duration{50 * time.Millisecond, 0}, 0, 201, 1},
+		{PolicyAll, []int{200, 201}, []time.Duration{0, 0}, 0, 200, 0},
+		{PolicyAll, []int{200, 503}, []time.Duration{0, 0}, 0, 503, 1},
+	}
+
+	for i, test := range tests {
+		var count int32
+
+		backends := []*Backend{}
+		for j := range test.statuses {
+			ts, b := newBackend(t, test.statuses[j], test.delays[j], &count)
+			defer ts.Close()
+			b.Primary = (j == test.primary)
+			backends = append(backends, b)
+		}
+
+		h, err := NewHandler(HandlerConfig{
+			Name:     "test",
+			Parser:   &testParser{},
+			Backends: backends,
+			Policy:   test.policy,
+		})
+		if err != nil {
+			t.Fatalf("[%d] handler error: %v", i, err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("[%d] invalid status: %d", i, rec.Code)
+		}
+
+		body, _ := ioutil.ReadAll(rec.Body)
+		if string(body) != backends[test.from].URL.Host {
+			t.Errorf("[%d] invalid response backend: %s", i, body)
+		}
+
+		// Wait for the deliveries in background.
+		time.Sleep(100 * time.Millisecond)
+		if int(atomic.LoadInt32(&count)) != len(backends) {
+			t.Errorf("[%d] invalid delivery count: %d", i, count)
+		}
+	}
+}
+
+func TestHandlerStructured(t *testing.T) {
+	tests := []struct {
+		contentType string
+		payload     string
+		data        string
+		dataBase64  string
+	}{
+		{"application/json", `{"action":"opened"}`, `{"action":"opened"}`, ""},
+		{"application/x-www-form-urlencoded", "command=%2Ftest&text=a", "", "Y29tbWFuZD0lMkZ0ZXN0JnRleHQ9YQ=="},
+	}
+
+	for i, test := range tests {
+		var (
+			contentType string
+			body        []byte
+		)
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			contentType = req.Header.Get("Content-Type")
+			body, _ = ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		u, _ := url.Parse(ts.URL)
+		h, err := NewHandler(HandlerConfig{
+			Name:     "test",
+			Parser:   &testParser{contentType: test.contentType},
+			Backends: []*Backend{{Name: "backend", URL: u}},
+			Mode:     cloudevents.ModeStructured,
+		})
+		if err != nil {
+			t.Fatalf("[%d] handler error: %v", i, err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.payload))
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("[%d] invalid status: %d", i, rec.Code)
+		}
+		if contentType != cloudevents.StructuredContentType {
+			t.Errorf("[%d] invalid content type: %s", i, contentType)
+		}
+
+		var m map[string]json.RawMessage
+		err = json.Unmarshal(body, &m)
+		if err != nil {
+			t.Fatalf("[%d] invalid body: %v", i, err)
+		}
+
+		if string(m["id"]) != `"1"` || string(m["type"]) != `"com.example.test"` {
+			t.Errorf("[%d] invalid attributes: %s", i, body)
+		}
+		if string(m["datacontenttype"]) != fmt.Sprintf("%q", test.contentType) {
+			t.Errorf("[%d] invalid datacontenttype: %s", i, m["datacontenttype"])
+		}
+		if string(m["data"]) != test.data {
+			t.Errorf("[%d] invalid data: %s", i, m["data"])
+		}
+
+		var dataBase64 string
+		if m["data_base64"] != nil {
+			json.Unmarshal(m["data_base64"], &dataBase64)
+		}
+		if dataBase64 != test.dataBase64 {
+			t.Errorf("[%d] invalid data_base64: %s", i, m["data_base64"])
+		}
+	}
+}
+
+func TestHandlerMatch(t *testing.T) {
+	tests := []struct {
+		matches []map[string]string
+		status  int
+		from    int
+		count   int
+	}{
+		{[]map[string]string{nil, nil}, 200, 0, 2},
+		{[]map[string]string{{"type": "com.example.other"}, {"type": "com.example.test"}}, 201, 1, 1},
+		{[]map[string]string{{"source": "/test", "type": "com.example.test"}, {"source": "/other"}}, 200, 0, 1},
+		{[]map[string]string{{"installation": "1"}, {"type": "com.example.other"}}, 204, -1, 0},
+	}
+
+	for i, test := range tests {
+		var count int32
+
+		backends := []*Backend{}
+		for j, match := range test.matches {
+			ts, b := newBackend(t, 200+j, 0, &count)
+			defer ts.Close()
+			b.Match = match
+			backends = append(backends, b)
+		}
+
+		h, err := NewHandler(HandlerConfig{
+			Name:     "test",
+			Parser:   &testParser{},
+			Backends: backends,
+		})
+		if err != nil {
+			t.Fatalf("[%d] handler error: %v", i, err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("[%d] invalid status: %d", i, rec.Code)
+		}
+
+		body, _ := ioutil.ReadAll(rec.Body)
+		if test.from >= 0 && string(body) != backends[test.from].URL.Host {
+			t.Errorf("[%d] invalid response backend: %s", i, body)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if int(atomic.LoadInt32(&count)) != test.count {
+			t.Errorf("[%d] invalid delivery count: %d", i, count)
+		}
+	}
+}
+
+func TestHandlerAsync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := queue.New(dir)
+	if err != nil {
+		t.Fatalf("queue error: %v", err)
+	}
+
+	payload := "{\n \"body\": \"a && b <tag>\"\n}"
+
+	// The backend fails on the first attempt as if it is being
+	// deployed.
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != payload {
+			t.Errorf("invalid body: %s", body)
+		}
+		if req.Header.Get("X-Hub-Signature") != "sha1=test" {
+			t.Errorf("invalid signature header: %s", req.Header.Get("X-Hub-Signature"))
+		}
+
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	h, err := NewHandler(HandlerConfig{
+		Name:     "test",
+		Parser:   &testParser{},
+		Backends: []*Backend{{Name: "backend", URL: u}},
+		Retry:    RetryConfig{InitialBackoff: 10 * time.Millisecond},
+		Queue:    q,
+	})
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload))
+	req.Header.Set("X-Hub-Signature", "sha1=test")
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("invalid status: %d", rec.Code)
+	}
+	if q.Len() != 1 || atomic.LoadInt32(&count) != 0 {
+		t.Fatalf("event must be queued: %d", q.Len())
+	}
+
+	err = q.Start(1, h.Deliver)
+	if err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	defer q.Stop()
+
+	var files []string
+	for i := 0; i < 100; i++ {
+		files, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+		if atomic.LoadInt32(&count) == 2 && len(files) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&count) != 2 {
+		t.Errorf("invalid delivery count: %d", count)
+	}
+	if len(files) != 0 {
+		t.Errorf("delivered event must be removed: %v", files)
+	}
+}
+
+func TestHandlerDeadLetter(t *testing.T) {
+	tests := []struct {
+		name  string
+		async bool
+		files int
+	}{
+		// Failures of synchronous delivery are returned to the sender.
+		{"sync", false, 0},
+		{"async", true, 1},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "deadletter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		sink, err := deadletter.NewDirSink(filepath.Join(dir, "dead-letter"))
+		if err != nil {
+			t.Fatalf("[%s] sink error: %v", test.name, err)
+		}
+
+		var q *queue.Queue
+		if test.async {
+			q, err = queue.New(filepath.Join(dir, "queue"))
+			if err != nil {
+				t.Fatalf("[%s] queue error: %v", test.name, err)
+			}
+		}
+
+		var count int32
+		ts, b := newBackend(t, http.StatusServiceUnavailable, 0, &count)
+		defer ts.Close()
+		b.Name = "backend"
+
+		h, err := NewHandler(HandlerConfig{
+			Name:       "test",
+			Parser:     &testParser{},
+			Backends:   []*Backend{b},
+			Retry:      RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			Queue:      q,
+			DeadLetter: sink,
+		})
+		if err != nil {
+			t.Fatalf("[%s] handler error: %v", test.name, err)
+		}
+
+		if q != nil {
+			err = q.Start(1, h.Deliver)
+			if err != nil {
+				t.Fatalf("[%s] start error: %v", test.name, err)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		h.ServeHTTP(rec, req)
+
+		// Wait for the queued event to be dead-lettered.
+		var files []string
+		for i := 0; i < 100; i++ {
+			files, err = deadletter.Files(filepath.Join(dir, "dead-letter"))
+			if err != nil {
+				t.Fatalf("[%s] files error: %v", test.name, err)
+			}
+			if q == nil || len(files) > 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if q != nil {
+			q.Stop()
+		}
+
+		if !test.async && rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("[%s] invalid status: %d", test.name, rec.Code)
+		}
+		if atomic.LoadInt32(&count) != 2 {
+			t.Errorf("[%s] invalid delivery count: %d", test.name, count)
+		}
+		if len(files) != test.files {
+			t.Fatalf("[%s] invalid number of dead-lettered events: %d", test.name, len(files))
+		}
+
+		for _, p := range files {
+			ce, f, err := deadletter.ReadFile(p)
+			if err != nil {
+				t.Fatalf("[%s] invalid file: %v", test.name, err)
+			}
+			if ce.ID != "1" {
+				t.Errorf("[%s] invalid event ID: %s", test.name, ce.ID)
+			}
+			if f.Route != "test" || f.Backend != "backend" || f.Status != http.StatusServiceUnavailable || f.Attempts != 2 {
+				t.Errorf("[%s] invalid failure: %+v", test.name, f)
+			}
+		}
+	}
+}
+
+func TestHandlerDeliver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := deadletter.NewDirSink(dir)
+	if err != nil {
+		t.Fatalf("sink error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		backend string
+		sink    deadletter.Sink
+		err     bool
+		invalid bool
+	}{
+		{"delivered", http.StatusOK, "backend", nil, false, false},
+		// The queue keeps the item unless it has been dead-lettered.
+		{"failed", http.StatusServiceUnavailable, "backend", nil, true, false},
+		{"dead-lettered", http.StatusServiceUnavailable, "backend", sink, false, false},
+		{"unknown-backend", http.StatusOK, "unknown", nil, true, true},
+	}
+
+	for _, test := range tests {
+		ts, b := newBackend(t, test.status, 0, nil)
+		defer ts.Close()
+		b.Name = "backend"
+
+		h, err := NewHandler(HandlerConfig{
+			Name:       "test",
+			Parser:     &testParser{},
+			Backends:   []*Backend{b},
+			Retry:      RetryConfig{MaxAttempts: 1},
+			DeadLetter: test.sink,
+		})
+		if err != nil {
+			t.Fatalf("[%s] handler error: %v", test.name, err)
+		}
+
+		ce, _ := (&testParser{}).Parse(nil)
+		err = h.Deliver(context.Background(), &queue.Item{Backend: test.backend, Event: ce})
+		if test.err != (err != nil) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+		if test.invalid != errors.Is(err, queue.ErrInvalid) {
+			t.Errorf("[%s] invalid error kind: %v", test.name, err)
+		}
+	}
+}
+
+func TestHandlerMultipleEvents(t *testing.T) {
+	tests := []struct {
+		n      int
+		status int
+	}{
+		{3, http.StatusOK},
+		{0, http.StatusNoContent},
+	}
+
+	for i, test := range tests {
+		var count int32
+
+		ts, b := newBackend(t, 200, 0, &count)
+		defer ts.Close()
+
+		h, err := NewHandler(HandlerConfig{
+			Name:     "test",
+			Parser:   &testMultiParser{n: test.n},
+			Backends: []*Backend{b},
+		})
+		if err != nil {
+			t.Fatalf("[%d] handler error: %v", i, err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("[%d] invalid status: %d", i, rec.Code)
+		}
+		if int(atomic.LoadInt32(&count)) != test.n {
+			t.Errorf("[%d] invalid delivery count: %d", i, count)
+		}
+	}
+}
+
+func TestHandlerParseError(t *testing.T) {
+	testCases := []struct {
+		err    error
+		status int
+	}{
+		{errors.New("invalid payload"), http.StatusBadRequest},
+		{webhook.BadRequest(errors.New("empty payload")), http.StatusBadRequest},
+		{webhook.Unauthorized(errors.New("invalid signature")), http.StatusUnauthorized},
+		{webhook.UnsupportedEvent(errors.New("unknown event")), http.StatusUnprocessableEntity},
+		{webhook.Ignored(errors.New("ping")), http.StatusNoContent},
+		{webhook.Upstream(errors.New("unavailable")), http.StatusBadGateway},
+		{&webhook.Reply{StatusCode: http.StatusOK, Body: []byte("challenge")}, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		var count int32
+
+		ts, b := newBackend(t, 200, 0, &count)
+		defer ts.Close()
+
+		h, err := NewHandler(HandlerConfig{
+			Name:     "test",
+			Parser:   &testParser{err: tc.err},
+			Backends: []*Backend{b},
+		})
+		if err != nil {
+			t.Fatalf("[%d] handler error: %v", i, err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("[%d] invalid status: %d", i, rec.Code)
+		}
+		if count != 0 {
+			t.Errorf("[%d] backend must not be called: %d", i, count)
+		}
+	}
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	var count int32
+
+	// The backend fails the first attempt to record both responses.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	b := &Backend{Name: "backend", URL: u}
+
+	route := "metrics"
+	parsers := []webhook.Parser{
+		&testParser{},
+		&testParser{err: webhook.Unauthorized(errors.New("invalid signature"))},
+		&testParser{err: webhook.BadRequest(errors.New("empty payload"))},
+		&testParser{err: webhook.Upstream(errors.New("unavailable"))},
+	}
+
+	// The collectors are global, so the values are compared with the
+	// values before the requests.
+	counters := []struct {
+		name     string
+		counter  prometheus.Counter
+		expected float64
+		before   float64
+	}{
+		{"requests", metrics.Requests.WithLabelValues(route, "com.example.test"), 1, 0},
+		{"requests without type", metrics.Requests.WithLabelValues(route, ""), 3, 0},
+		{"signature failures", metrics.SignatureFailures.WithLabelValues(route), 1, 0},
+		{"parse failures", metrics.ParseFailures.WithLabelValues(route), 1, 0},
+		{"forwarded", metrics.Forwarded.WithLabelValues(route, "com.example.test"), 1, 0},
+		{"backend 503", metrics.BackendResponses.WithLabelValues(route, "backend", "503"), 1, 0},
+		{"backend 200", metrics.BackendResponses.WithLabelValues(route, "backend", "200"), 1, 0},
+	}
+
+	for i := range counters {
+		counters[i].before = testutil.ToFloat64(counters[i].counter)
+	}
+	samples := sampleCount(t, route, "backend")
+
+	for i, p := range parsers {
+		h, err := NewHandler(HandlerConfig{
+			Name:     route,
+			Parser:   p,
+			Backends: []*Backend{b},
+			Retry: RetryConfig{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+			},
+		})
+		if err != nil {
+			t.Fatalf("[%d] handler error: %v", i, err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		h.ServeHTTP(rec, req)
+	}
+
+	for _, c := range counters {
+		v := testutil.ToFloat64(c.counter) - c.before
+		if v != c.expected {
+			t.Errorf("[%s] invalid value: %v", c.name, v)
+		}
+	}
+
+	if n := sampleCount(t, route, "backend") - samples; n != 2 {
+		t.Errorf("invalid sample count: %d", n)
+	}
+}
+
+// sampleCount returns the number of observations of the backend
+// duration histogram.
+func sampleCount(t *testing.T, route, backend string) uint64 {
+	var m dto.Metric
+	err := metrics.BackendDuration.WithLabelValues(route, backend).(prometheus.Histogram).Write(&m)
+	if err != nil {
+		t.Fatalf("histogram error: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
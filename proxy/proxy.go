@@ -1,21 +1,270 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/metrics"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
 )
 
+// defaultStatusCodes is the list of status codes to retry by default.
+var defaultStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+type eventIDKey struct{}
+
+type attemptsKey struct{}
+
+type backendKey struct{}
+
+// withEventID returns a context that carries the event ID.
+func withEventID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, eventIDKey{}, id)
+}
+
+// withAttempts returns a context that records the number of attempts
+// of the request to n.
+func withAttempts(ctx context.Context, n *int) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, n)
+}
+
+// setAttempts records the number of attempts of the request.
+func setAttempts(req *http.Request, attempt int) {
+	n, ok := req.Context().Value(attemptsKey{}).(*int)
+	if ok {
+		*n = attempt
+	}
+}
+
+// withBackend returns a context that carries the name of the backend.
+func withBackend(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, backendKey{}, name)
+}
+
+// backendName returns the name of the backend of the request.
+func backendName(req *http.Request) string {
+	name, ok := req.Context().Value(backendKey{}).(string)
+	if ok && name != "" {
+		return name
+	}
+	return req.URL.Host
+}
+
+// eventID returns the event ID of the request.
+func eventID(req *http.Request) string {
+	id, ok := req.Context().Value(eventIDKey{}).(string)
+	if ok && id != "" {
+		return id
+	}
+	return req.Header.Get("CE-ID")
+}
+
+// RetryConfig is the configuration of retries for failed requests.
+// Requests are not retried if MaxAttempts is less than 2.
+type RetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64
+	StatusCodes    []int
+}
+
 type Transport struct {
-	base http.RoundTripper
+	base  http.RoundTripper
+	retry RetryConfig
+	route string
 }
 
-func NewTransport() Transport {
-	return Transport{base: http.DefaultTransport}
+func NewTransport(retry RetryConfig) Transport {
+	if retry.InitialBackoff <= 0 {
+		retry.InitialBackoff = defaultInitialBackoff
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = defaultMaxBackoff
+	}
+	if retry.Jitter < 0 {
+		retry.Jitter = 0
+	}
+	if retry.Jitter > 1 {
+		retry.Jitter = 1
+	}
+	if len(retry.StatusCodes) == 0 {
+		retry.StatusCodes = defaultStatusCodes
+	}
+
+	return Transport{base: http.DefaultTransport, retry: retry}
 }
 
 func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if req.Header.Get("CE-ID") == "" {
+	id := eventID(req)
+	if id == "" {
 		return nil, errors.New("invalid request")
 	}
-	return t.base.RoundTrip(req)
+
+	if t.retry.MaxAttempts < 2 {
+		setAttempts(req, 1)
+		return t.roundTrip(req)
+	}
+
+	// Buffer the request body to replay it on retries.
+	getBody := req.GetBody
+	if getBody == nil && req.Body != nil && req.Body != http.NoBody {
+		buf, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		getBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(buf)), nil
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req.Clone(req.Context())
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+
+		setAttempts(req, attempt)
+		resp, err := t.roundTrip(r)
+		if attempt >= t.retry.MaxAttempts || !t.retryable(resp, err) {
+			if attempt > 1 {
+				log.Printf("event_id:%s host:%s attempts:%d", id, req.URL.Host, attempt)
+			}
+			return resp, err
+		}
+
+		wait := t.backoff(attempt, resp)
+
+		if err != nil {
+			log.Printf("event_id:%s host:%s attempt:%d error:%s retry_after:%s", id, req.URL.Host, attempt, err, wait)
+		} else {
+			log.Printf("event_id:%s host:%s attempt:%d status:%d retry_after:%s", id, req.URL.Host, attempt, resp.StatusCode, wait)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// roundTrip sends the request once and records the metrics.
+func (t Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	backend := backendName(req)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	metrics.BackendDuration.WithLabelValues(t.route, backend).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.BackendResponses.WithLabelValues(t.route, backend, code).Inc()
+
+	return resp, err
+}
+
+// retryable returns true if the request should be retried.
+func (t Transport) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return err != context.Canceled && err != context.DeadlineExceeded
+	}
+
+	for _, code := range t.retry.StatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the duration to wait before the next attempt. The
+// duration grows exponentially with the attempt and is extended to the
+// value of Retry-After header if any.
+func (t Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	d := float64(t.retry.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	if t.retry.Jitter > 0 {
+		d = d * (1 - t.retry.Jitter + 2*t.retry.Jitter*rand.Float64())
+	}
+
+	// Cap the duration before the conversion, which overflows for large
+	// attempts.
+	if d > float64(t.retry.MaxBackoff) {
+		d = float64(t.retry.MaxBackoff)
+	}
+
+	wait := time.Duration(d)
+	if resp != nil {
+		ra := retryAfter(resp.Header.Get("Retry-After"))
+		if ra > wait {
+			wait = ra
+		}
+	}
+
+	if wait > t.retry.MaxBackoff {
+		wait = t.retry.MaxBackoff
+	}
+
+	return wait
+}
+
+// retryAfter parses the value of Retry-After header. It returns zero
+// if the value is empty or invalid.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	sec, err := strconv.Atoi(v)
+	if err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+
+	d := time.Until(t)
+	if d < 0 {
+		return 0
+	}
+
+	return d
 }
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		failures    int
+		status      int
+		attempts    int
+		result      int
+	}{
+		{"success", 3, 0, 503, 1, 200},
+		{"retry", 3, 2, 503, 3, 200},
+		{"exhausted", 3, 5, 503, 3, 503},
+		{"not-retryable", 3, 5, 400, 1, 400},
+		{"disabled", 0, 5, 503, 1, 503},
+	}
+
+	for _, test := range tests {
+		var count int32
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			n := atomic.AddInt32(&count, 1)
+
+			body, _ := ioutil.ReadAll(req.Body)
+			if string(body) != "payload" {
+				t.Errorf("[%s] invalid body: %s", test.name, body)
+			}
+
+			if int(n) <= test.failures {
+				w.WriteHeader(test.status)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		transport := NewTransport(RetryConfig{
+			MaxAttempts:    test.maxAttempts,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond,
+			Jitter:         0.5,
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, ioutil.NopCloser(strings.NewReader("payload")))
+		req = req.WithContext(withEventID(context.Background(), "1"))
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", test.name, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.result {
+			t.Errorf("[%s] invalid status: %d", test.name, resp.StatusCode)
+		}
+		if int(count) != test.attempts {
+			t.Errorf("[%s] invalid attempts: %d", test.name, count)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestTransportWithoutEventID(t *testing.T) {
+	transport := NewTransport(RetryConfig{})
+
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1", nil)
+	_, err := transport.RoundTrip(req)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	transport := NewTransport(RetryConfig{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	})
+
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		backoff    time.Duration
+	}{
+		{1, "", 100 * time.Millisecond},
+		{2, "", 200 * time.Millisecond},
+		{5, "", time.Second},
+		{36, "", time.Second},
+		{1000, "", time.Second},
+		{1000, "1", time.Second},
+		{1, "0", 100 * time.Millisecond},
+		{1, "1", time.Second},
+		{1, "120", time.Second},
+		{1, "invalid", 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", test.retryAfter)
+
+		d := transport.backoff(test.attempt, resp)
+		if d != test.backoff {
+			t.Errorf("[%d:%s] invalid backoff: %s", test.attempt, test.retryAfter, d)
+		}
+	}
+}
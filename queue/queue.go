@@ -0,0 +1,326 @@
+// Package queue implements a durable queue of events backed by files
+// in a directory.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+)
+
+const (
+	fileExt    = ".json"
+	invalidExt = ".invalid"
+
+	// defaultRetryDelay is the delay before the item that failed to be
+	// delivered is delivered again.
+	defaultRetryDelay = time.Minute
+)
+
+// ErrInvalid indicates that the item can never be delivered. The
+// DeliverFunc returns an error wrapping it to discard the item.
+var ErrInvalid = errors.New("invalid item")
+
+// Item is the unit of the queue. It holds an event to be delivered to
+// the backend.
+type Item struct {
+	Backend string             `json:"backend"`
+	Header  http.Header        `json:"header,omitempty"`
+	Event   *cloudevents.Event `json:"event"`
+}
+
+// record is the item stored in the file. The data of the event is
+// stored separately from the event, since the JSON format of
+// CloudEvents re-encodes JSON data and the data must be delivered
+// byte-for-byte to keep the signature of the webhook valid.
+type record struct {
+	Backend string             `json:"backend"`
+	Header  http.Header        `json:"header,omitempty"`
+	Event   *cloudevents.Event `json:"event"`
+	Data    []byte             `json:"data,omitempty"`
+}
+
+// DeliverFunc delivers the item. The item is removed from the queue
+// when it returns nil. If it returns an error, the item is kept and
+// delivered again after the retry delay, unless the error wraps
+// ErrInvalid.
+type DeliverFunc func(ctx context.Context, item *Item) error
+
+// Queue is a durable queue of items. Each item is stored as a file in
+// the directory until it is delivered, so the items that have not been
+// delivered are loaded again when the queue is started.
+type Queue struct {
+	dir        string
+	seq        uint64
+	retryDelay time.Duration
+	mu         sync.Mutex
+	cond       *sync.Cond
+	pending    []string
+	closed     bool
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// New returns a new queue that stores items in the directory. The
+// directory is created if it does not exist.
+func New(dir string) (*Queue, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{dir: dir, retryDelay: defaultRetryDelay}
+	q.cond = sync.NewCond(&q.mu)
+
+	return q, nil
+}
+
+// Put stores the item to the directory and schedules its delivery.
+func (q *Queue) Put(item *Item) error {
+	if item.Event == nil {
+		return errors.New("no event")
+	}
+
+	ce := *item.Event
+	ce.Data = nil
+
+	buf, err := json.Marshal(&record{
+		Backend: item.Backend,
+		Header:  item.Header,
+		Event:   &ce,
+		Data:    item.Event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), atomic.AddUint64(&q.seq, 1), fileExt)
+	tmp := filepath.Join(q.dir, fmt.Sprintf(".%s.tmp", name))
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(q.dir, name))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	q.push(name)
+
+	return nil
+}
+
+// Start loads the items stored in the directory and starts the
+// specified number of workers to deliver items.
+func (q *Queue) Start(workers int, fn DeliverFunc) error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		// Temporary files are left only if the process exited while
+		// writing them, that is, the items have not been accepted.
+		if strings.HasPrefix(f.Name(), ".") && strings.HasSuffix(f.Name(), ".tmp") {
+			os.Remove(filepath.Join(q.dir, f.Name()))
+			continue
+		}
+
+		if strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+
+	// Items put before the start are already pending.
+	q.mu.Lock()
+	pending := map[string]bool{}
+	for _, name := range q.pending {
+		pending[name] = true
+	}
+	loaded := []string{}
+	for _, name := range names {
+		if !pending[name] {
+			loaded = append(loaded, name)
+		}
+	}
+	q.pending = append(loaded, q.pending...)
+	q.mu.Unlock()
+
+	if len(loaded) > 0 {
+		log.Printf("queue:%s loaded:%d", q.dir, len(loaded))
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx, fn)
+	}
+
+	return nil
+}
+
+// Stop stops workers and waits for them to exit. The items being
+// delivered are kept in the directory.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	if q.cancel != nil {
+		q.cancel()
+	}
+
+	q.wg.Wait()
+}
+
+// Len returns the number of items waiting for delivery.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *Queue) push(name string) {
+	q.mu.Lock()
+	q.pending = append(q.pending, name)
+	q.cond.Signal()
+	q.mu.Unlock()
+}
+
+// retry schedules the item to be delivered again after the retry delay.
+// The item is loaded again by Start if the queue has been stopped.
+func (q *Queue) retry(name string) {
+	time.AfterFunc(q.retryDelay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if q.closed {
+			return
+		}
+		q.pending = append(q.pending, name)
+		q.cond.Signal()
+	})
+}
+
+func (q *Queue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if q.closed {
+		return "", false
+	}
+
+	name := q.pending[0]
+	q.pending = q.pending[1:]
+
+	return name, true
+}
+
+func (q *Queue) work(ctx context.Context, fn DeliverFunc) {
+	defer q.wg.Done()
+
+	for {
+		name, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		p := filepath.Join(q.dir, name)
+
+		item, err := load(p)
+		if err != nil {
+			log.Printf("queue:%s file:%s error:%s", q.dir, name, err)
+			os.Rename(p, p+invalidExt)
+			continue
+		}
+
+		err = fn(ctx, item)
+		if ctx.Err() != nil {
+			// Keep the item to deliver it after restart.
+			return
+		}
+		if errors.Is(err, ErrInvalid) {
+			log.Printf("queue:%s event_id:%s backend:%s error:%s", q.dir, item.Event.ID, item.Backend, err)
+			os.Rename(p, p+invalidExt)
+			continue
+		}
+		if err != nil {
+			log.Printf("queue:%s event_id:%s backend:%s error:%s retry_after:%s", q.dir, item.Event.ID, item.Backend, err, q.retryDelay)
+			q.retry(name)
+			continue
+		}
+
+		err = os.Remove(p)
+		if err != nil {
+			log.Printf("queue:%s file:%s error:%s", q.dir, name, err)
+		}
+	}
+}
+
+// load reads the item from the file.
+func load(p string) (*Item, error) {
+	buf, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec record
+	err = json.Unmarshal(buf, &rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.Event == nil {
+		return nil, errors.New("no event")
+	}
+
+	rec.Event.Data = rec.Data
+
+	return &Item{Backend: rec.Backend, Header: rec.Header, Event: rec.Event}, nil
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+)
+
+func newItem(id string) *Item {
+	s, _ := url.Parse("/test")
+
+	return &Item{
+		Backend: "backend",
+		Event: &cloudevents.Event{
+			ID:              id,
+			Type:            "com.example.test",
+			Source:          *s,
+			DataContentType: "application/json",
+			Data:            []byte(`{"id":"` + id + `"}`),
+		},
+	}
+}
+
+// countFiles returns the number of items stored in the directory.
+func countFiles(t *testing.T, dir string) int {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+// count returns the number of the ID in the IDs.
+func count(ids []string, id string) int {
+	n := 0
+	for _, v := range ids {
+		if v == id {
+			n++
+		}
+	}
+	return n
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir)
+	if err != nil {
+		t.Fatalf("queue error: %v", err)
+	}
+	q.retryDelay = 10 * time.Millisecond
+
+	var (
+		mu  sync.Mutex
+		ids []string
+	)
+
+	err = q.Start(2, func(ctx context.Context, item *Item) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if string(item.Event.Data) != `{"id":"`+item.Event.ID+`"}` {
+			t.Errorf("invalid data: %s", item.Event.Data)
+		}
+		ids = append(ids, item.Event.ID)
+
+		// The failed item is delivered again after the retry delay.
+		if item.Event.ID == "fail" && count(ids, "fail") == 1 {
+			return errors.New("delivery failed")
+		}
+		if item.Event.ID == "invalid" {
+			return fmt.Errorf("%w: unknown backend", ErrInvalid)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	defer q.Stop()
+
+	for _, id := range []string{"1", "2", "fail", "invalid"} {
+		err := q.Put(newItem(id))
+		if err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	ok := waitFor(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ids) == 5 && countFiles(t, dir) == 0
+	})
+	if !ok {
+		t.Errorf("items are not delivered: %v", ids)
+	}
+
+	// Invalid items are kept for inspection but never delivered.
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+invalidExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("invalid item must be kept: %v", matches)
+	}
+}
+
+func TestQueueRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir)
+	if err != nil {
+		t.Fatalf("queue error: %v", err)
+	}
+
+	started := make(chan struct{})
+	err = q.Start(1, func(ctx context.Context, item *Item) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		err := q.Put(newItem(id))
+		if err != nil {
+			t.Fatalf("put error: %v", err)
+		}
+	}
+
+	<-started
+	q.Stop()
+
+	if countFiles(t, dir) != 2 {
+		t.Fatalf("items must be kept: %d", countFiles(t, dir))
+	}
+
+	q, err = New(dir)
+	if err != nil {
+		t.Fatalf("queue error: %v", err)
+	}
+
+	var (
+		mu  sync.Mutex
+		ids []string
+	)
+
+	err = q.Start(1, func(ctx context.Context, item *Item) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, item.Event.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	defer q.Stop()
+
+	ok := waitFor(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ids) == 2 && countFiles(t, dir) == 0
+	})
+	if !ok {
+		t.Fatalf("items are not delivered after restart: %v", ids)
+	}
+	if ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("invalid order: %v", ids)
+	}
+}
+
+func TestQueueData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir)
+	if err != nil {
+		t.Fatalf("queue error: %v", err)
+	}
+
+	tests := []struct {
+		contentType string
+		data        string
+	}{
+		{"application/json", "{\n \"body\": \"a && b <tag>\"\n}"},
+		{"application/x-www-form-urlencoded", "payload=%7B%22a%22%3A1%7D"},
+		{"", ""},
+	}
+
+	for i, test := range tests {
+		item := newItem(strconv.Itoa(i))
+		item.Event.DataContentType = test.contentType
+		item.Event.Data = []byte(test.data)
+		if test.data == "" {
+			item.Event.Data = nil
+		}
+
+		err := q.Put(item)
+		if err != nil {
+			t.Fatalf("[%d] put error: %v", i, err)
+		}
+		if string(item.Event.Data) != test.data {
+			t.Errorf("[%d] event must not be modified: %s", i, item.Event.Data)
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		data = map[string]string{}
+	)
+
+	err = q.Start(1, func(ctx context.Context, item *Item) error {
+		mu.Lock()
+		defer mu.Unlock()
+		data[item.Event.ID] = string(item.Event.Data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	defer q.Stop()
+
+	ok := waitFor(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(data) == len(tests)
+	})
+	if !ok {
+		t.Fatalf("items are not delivered: %v", data)
+	}
+
+	for i, test := range tests {
+		if data[strconv.Itoa(i)] != test.data {
+			t.Errorf("[%d] invalid data: %q", i, data[strconv.Itoa(i)])
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/summerwind/cloudevents-webhook-gateway/deadletter"
+	"github.com/summerwind/cloudevents-webhook-gateway/queue"
+)
+
+func newRedriveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "redrive [flags] PATH...",
+		Short: "Deliver dead-lettered events to the backends again",
+		Long: `Deliver dead-lettered events to the backends again.
+
+PATH is a dead-lettered event file or a directory of them. Each event is
+delivered to the backend of the route recorded in the event. Files of the
+delivered events are removed, and files of the events that failed again
+are kept.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: redrive,
+
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.Flags().StringP("config", "c", "config.yml", "Path to the configuration file")
+	cmd.Flags().String("route", "", "Name of the route to deliver events instead of the recorded one")
+	cmd.Flags().String("backend", "", "Name of the backend to deliver events instead of the recorded one")
+
+	return cmd
+}
+
+// redrive delivers dead-lettered events to the backends again.
+func redrive(cmd *cobra.Command, args []string) error {
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+
+	routeName, err := cmd.Flags().GetString("route")
+	if err != nil {
+		return err
+	}
+
+	backendName, err := cmd.Flags().GetString("backend")
+	if err != nil {
+		return err
+	}
+
+	c, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	routes, err := newRoutes(c)
+	if err != nil {
+		return err
+	}
+
+	handlers := map[string]*route{}
+	for _, r := range routes {
+		handlers[r.config.Name] = r
+	}
+
+	paths := []string{}
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		files, err := deadletter.Files(arg)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, files...)
+	}
+
+	failed := 0
+	for _, p := range paths {
+		ce, f, err := deadletter.ReadFile(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+			failed++
+			continue
+		}
+
+		if routeName != "" {
+			f.Route = routeName
+		}
+		if backendName != "" {
+			f.Backend = backendName
+		}
+
+		r, ok := handlers[f.Route]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: unknown route: %s\n", p, f.Route)
+			failed++
+			continue
+		}
+
+		err = r.handler.Redrive(context.Background(), &queue.Item{Backend: f.Backend, Header: f.Header, Event: ce})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", p, err)
+			failed++
+			continue
+		}
+
+		err = os.Remove(p)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: delivered event %s to %s\n", p, ce.ID, f.Backend)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d events failed", failed, len(paths))
+	}
+
+	return nil
+}
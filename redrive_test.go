@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/deadletter"
+)
+
+func TestRedrive(t *testing.T) {
+	// The payload is delivered byte-for-byte to keep the signature valid.
+	payload := "{\n  \"ref\": \"refs/heads/main\"\n}\n"
+
+	tests := []struct {
+		name   string
+		status int
+		route  string
+		err    bool
+		kept   bool
+	}{
+		{"delivered", http.StatusOK, "github", false, false},
+		{"failed", http.StatusServiceUnavailable, "github", true, true},
+		{"unknown-route", http.StatusOK, "unknown", true, true},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "redrive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var (
+			id        string
+			body      []byte
+			signature string
+		)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id = req.Header.Get("ce-id")
+			signature = req.Header.Get("X-Hub-Signature")
+			body, _ = ioutil.ReadAll(req.Body)
+			w.WriteHeader(test.status)
+		}))
+		defer ts.Close()
+
+		configPath := filepath.Join(dir, "config.yml")
+		config := fmt.Sprintf(`
+routes:
+- name: github
+  type: github
+  backends:
+  - name: ci
+    url: %s
+`, ts.URL)
+		err = ioutil.WriteFile(configPath, []byte(config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sink, err := deadletter.NewDirSink(filepath.Join(dir, "dead-letter"))
+		if err != nil {
+			t.Fatalf("[%s] sink error: %v", test.name, err)
+		}
+
+		s, _ := url.Parse("/test")
+		err = sink.Send(&cloudevents.Event{
+			ID:              "1",
+			Type:            "com.github.push",
+			Source:          *s,
+			DataContentType: "application/json",
+			Data:            []byte(payload),
+		}, &deadletter.Failure{
+			Route:    test.route,
+			Backend:  "ci",
+			Status:   http.StatusServiceUnavailable,
+			Attempts: 3,
+			Time:     time.Now(),
+			Header:   http.Header{"X-Hub-Signature": []string{"sha1=test"}},
+		})
+		if err != nil {
+			t.Fatalf("[%s] send error: %v", test.name, err)
+		}
+
+		cmd := newRedriveCommand()
+		cmd.SetArgs([]string{"--config", configPath, filepath.Join(dir, "dead-letter")})
+		err = cmd.Execute()
+		if test.err != (err != nil) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+
+		if test.route != "unknown" {
+			if id != "1" {
+				t.Errorf("[%s] invalid ce-id: %s", test.name, id)
+			}
+			if string(body) != payload {
+				t.Errorf("[%s] invalid body: %q", test.name, body)
+			}
+			if signature != "sha1=test" {
+				t.Errorf("[%s] invalid signature: %s", test.name, signature)
+			}
+		}
+
+		files, err := deadletter.Files(filepath.Join(dir, "dead-letter"))
+		if err != nil {
+			t.Fatalf("[%s] files error: %v", test.name, err)
+		}
+		if test.kept != (len(files) == 1) {
+			t.Errorf("[%s] invalid files: %v", test.name, files)
+		}
+	}
+}
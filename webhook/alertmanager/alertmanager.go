@@ -1,13 +1,30 @@
+// Package alertmanager implements the parser for Alertmanager webhook.
+//
+// By default, a notification is converted to an event. If the parser
+// is configured to split notifications, each alert in the notification
+// is converted to an event typed with the status of the alert, and the
+// alert is set as the data of the event.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - status: Status of the notification or the alert (firing or resolved)
+//   - receiver: Name of the receiver
 package alertmanager
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 
-	"github.com/prometheus/alertmanager/notify/webhook"
+	amwebhook "github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 const (
@@ -15,17 +32,44 @@ const (
 	contentType = "application/json"
 )
 
-type Parser struct{}
+func init() {
+	webhook.Register("alertmanager", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(opts.Bool("split")), nil
+	})
+}
+
+type Parser struct {
+	split bool
+}
 
-func NewParser() *Parser {
-	return &Parser{}
+// NewParser returns a new parser. If split is true, the parser
+// converts each alert in the notification to an event.
+func NewParser(split bool) *Parser {
+	return &Parser{
+		split: split,
+	}
 }
 
+// Parse returns the event of the notification, or the event of the
+// first alert if the parser splits notifications.
 func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
-	var msg webhook.Message
+	events, err := p.ParseAll(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, webhook.Ignored(errors.New("no alerts"))
+	}
+
+	return events[0], nil
+}
+
+func (p *Parser) ParseAll(req *http.Request) ([]*cloudevents.Event, error) {
+	var msg amwebhook.Message
 
 	if req.Body == nil {
-		return nil, errors.New("empty payload")
+		return nil, webhook.BadRequest(errors.New("empty payload"))
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -33,19 +77,77 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 
 	err := decoder.Decode(&msg)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
 	s, err := url.Parse(msg.ExternalURL)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
-	ce := &cloudevents.Event{
-		Type:            eventType,
-		Source:          *s,
-		DataContentType: contentType,
+	if !p.split {
+		values := []string{msg.GroupKey, msg.Status}
+		for _, alert := range msg.Alerts {
+			values = append(values, alertID(&alert), fmt.Sprintf("%d", alert.EndsAt.Unix()))
+		}
+
+		ce := &cloudevents.Event{
+			ID:              webhook.StableID(values...),
+			Type:            eventType,
+			Source:          *s,
+			DataContentType: contentType,
+		}
+
+		ce.SetExtension("status", msg.Status)
+		ce.SetExtension("receiver", msg.Receiver)
+
+		return []*cloudevents.Event{ce}, nil
+	}
+
+	events := []*cloudevents.Event{}
+	for _, alert := range msg.Alerts {
+		data, err := json.Marshal(alert)
+		if err != nil {
+			return nil, err
+		}
+
+		ce := &cloudevents.Event{
+			ID:              alertID(&alert),
+			Type:            fmt.Sprintf("%s.%s", eventType, alert.Status),
+			Source:          *s,
+			Subject:         alert.Labels["alertname"],
+			DataContentType: contentType,
+			Data:            data,
+		}
+
+		ce.SetExtension("status", alert.Status)
+		ce.SetExtension("receiver", msg.Receiver)
+
+		events = append(events, ce)
+	}
+
+	return events, nil
+}
+
+// alertID returns the ID of the alert event. The ID consists of the
+// fingerprint, the status and the start time of the alert, so that
+// notifications of the same alert get the same ID. The fingerprint is
+// computed from the labels if Alertmanager does not send it.
+func alertID(alert *template.Alert) string {
+	fp := alert.Fingerprint
+	if fp == "" {
+		names := make([]string, 0, len(alert.Labels))
+		for name := range alert.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		h := sha256.New()
+		for _, name := range names {
+			fmt.Fprintf(h, "%s=%s\n", name, alert.Labels[name])
+		}
+		fp = hex.EncodeToString(h.Sum(nil))[:16]
 	}
 
-	return ce, nil
+	return fmt.Sprintf("%s-%s-%d", fp, alert.Status, alert.StartsAt.Unix())
 }
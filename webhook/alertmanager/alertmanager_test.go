@@ -2,12 +2,17 @@ package alertmanager
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/alertmanager/template"
 )
 
 const (
@@ -32,7 +37,7 @@ func newRequest(name string) (*http.Request, error) {
 	}
 
 	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Content-Length", string(len(body)))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 
 	return req, nil
 }
@@ -43,7 +48,7 @@ func TestParse(t *testing.T) {
 		t.Fatalf("invalid request: %v", err)
 	}
 
-	p := NewParser()
+	p := NewParser(false)
 	ce, err := p.Parse(req)
 	if err != nil {
 		t.Fatalf("parser error: %v", err)
@@ -55,4 +60,139 @@ func TestParse(t *testing.T) {
 	if ce.Source.String() != "http://127.0.0.1:9093" {
 		t.Errorf("invalid source: %v", ce.Source)
 	}
+	if ce.Extensions["status"] != "firing" {
+		t.Errorf("invalid status: %v", ce.Extensions["status"])
+	}
+	if ce.Extensions["receiver"] != "team" {
+		t.Errorf("invalid receiver: %v", ce.Extensions["receiver"])
+	}
+}
+
+func TestParseStableID(t *testing.T) {
+	tests := []struct {
+		name     string
+		replacer *strings.Replacer
+		stable   bool
+	}{
+		{"redelivery", strings.NewReplacer(), true},
+		{"group", strings.NewReplacer(`alertname=\"TestAlert\"`, `alertname=\"OtherAlert\"`), false},
+		{"status", strings.NewReplacer(`"status": "firing"`, `"status": "resolved"`), false},
+		{"endsAt", strings.NewReplacer(`"endsAt": "0001-01-01T00:00:00Z"`, `"endsAt": "2019-01-03T22:30:08.822+09:00"`), false},
+	}
+
+	body, err := loadFixture("alert")
+	if err != nil {
+		t.Fatalf("invalid fixture: %v", err)
+	}
+
+	p := NewParser(false)
+	parse := func(body string) string {
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid request: %v", err)
+		}
+		req.Header.Set("Content-Type", ContentType)
+
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("parser error: %v", err)
+		}
+		return ce.ID
+	}
+
+	id := parse(string(body))
+	if id == "" {
+		t.Fatalf("ID must be set")
+	}
+
+	for _, test := range tests {
+		modified := test.replacer.Replace(string(body))
+		if !test.stable && modified == string(body) {
+			t.Fatalf("[%s] payload must be modified", test.name)
+		}
+
+		actual := parse(modified)
+		if test.stable && actual != id {
+			t.Errorf("[%s] ID must be stable: %s, %s", test.name, id, actual)
+		}
+		if !test.stable && actual == id {
+			t.Errorf("[%s] ID must differ: %s", test.name, actual)
+		}
+	}
+}
+
+func TestParseSplit(t *testing.T) {
+	tests := []struct {
+		ceID      string
+		ceType    string
+		ceSubject string
+		status    string
+	}{
+		{"a3e8b2c4d5f60718-firing-1546521608", "io.prometheus.alertmanager.alert.firing", "HighLoad", "firing"},
+		{"", "io.prometheus.alertmanager.alert.resolved", "InstanceDown", "resolved"},
+	}
+
+	req, err := newRequest("alerts")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	p := NewParser(true)
+	events, err := p.ParseAll(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	if len(events) != len(tests) {
+		t.Fatalf("invalid number of events: %d", len(events))
+	}
+
+	for i, test := range tests {
+		ce := events[i]
+
+		if test.ceID != "" && ce.ID != test.ceID {
+			t.Errorf("[%d] invalid ID: %v", i, ce.ID)
+		}
+		if ce.ID == "" {
+			t.Errorf("[%d] ID must be set", i)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%d] invalid type: %v", i, ce.Type)
+		}
+		if ce.Source.String() != "http://127.0.0.1:9093" {
+			t.Errorf("[%d] invalid source: %v", i, ce.Source)
+		}
+		if ce.Subject != test.ceSubject {
+			t.Errorf("[%d] invalid subject: %v", i, ce.Subject)
+		}
+		if ce.Extensions["status"] != test.status {
+			t.Errorf("[%d] invalid status: %v", i, ce.Extensions["status"])
+		}
+
+		var alert template.Alert
+		err := json.Unmarshal(ce.Data, &alert)
+		if err != nil {
+			t.Errorf("[%d] invalid data: %v", i, err)
+		}
+		if alert.Labels["alertname"] != test.ceSubject {
+			t.Errorf("[%d] data must be the alert: %s", i, ce.Data)
+		}
+	}
+
+	// The same alert must get the same ID.
+	req, err = newRequest("alerts")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	again, err := p.ParseAll(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	for i := range events {
+		if events[i].ID != again[i].ID {
+			t.Errorf("[%d] ID must be stable: %s, %s", i, events[i].ID, again[i].ID)
+		}
+	}
 }
@@ -1,3 +1,10 @@
+// Package anchoreengine implements the parser for Anchore Engine
+// webhook.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - subscriptionkey: Key of the subscription (e.g. image tag)
+//   - user: Name of the user who owns the subscription
 package anchoreengine
 
 import (
@@ -8,6 +15,7 @@ import (
 	"net/url"
 
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 type Webhook struct {
@@ -15,6 +23,7 @@ type Webhook struct {
 }
 
 type WebhookData struct {
+	NotificationUser    string                     `json:"notification_user"`
 	NotificationType    string                     `json:"notification_type"`
 	NotificationPayload WebhookNotificationPayload `json:"notification_payload"`
 }
@@ -24,6 +33,12 @@ type WebhookNotificationPayload struct {
 	SubscriptionKey string `json:"subscription_key"`
 }
 
+func init() {
+	webhook.Register("anchore-engine", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(), nil
+	})
+}
+
 type Parser struct{}
 
 func NewParser() *Parser {
@@ -34,7 +49,7 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 	var w Webhook
 
 	if req.Body == nil {
-		return nil, errors.New("empty payload")
+		return nil, webhook.BadRequest(errors.New("empty payload"))
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -42,13 +57,13 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 
 	err := decoder.Decode(&w)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
 	source := fmt.Sprintf("/v1/subscriptions?subscription_key=%s", w.Data.NotificationPayload.SubscriptionKey)
 	s, err := url.Parse(source)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
 	ce := &cloudevents.Event{
@@ -58,5 +73,8 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 		DataContentType: "application/json",
 	}
 
+	ce.SetExtension("subscriptionkey", w.Data.NotificationPayload.SubscriptionKey)
+	ce.SetExtension("user", w.Data.NotificationUser)
+
 	return ce, nil
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
 )
 
@@ -32,7 +33,7 @@ func newRequest(name string) (*http.Request, error) {
 	}
 
 	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Content-Length", string(len(body)))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 
 	return req, nil
 }
@@ -67,5 +68,11 @@ func TestParse(t *testing.T) {
 		if ce.Source.String() != test.ceSource {
 			t.Errorf("[%s] invalid source: %v", test.eventType, ce.Source)
 		}
+		if ce.Extensions["subscriptionkey"] != "docker.io/dnurmi/testrepo:latest" {
+			t.Errorf("[%s] invalid subscription key: %v", test.eventType, ce.Extensions["subscriptionkey"])
+		}
+		if ce.Extensions["user"] != "admin" {
+			t.Errorf("[%s] invalid user: %v", test.eventType, ce.Extensions["user"])
+		}
 	}
 }
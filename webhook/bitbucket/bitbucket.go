@@ -0,0 +1,265 @@
+// Package bitbucket implements the parsers for Bitbucket Cloud and
+// Bitbucket Server (Data Center) webhook.
+//
+// The parsers set the following extension attributes if available.
+//
+//   - repository: Full name of the repository
+//   - actor: Name of the user who triggered the event
+package bitbucket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	cloudTypePrefix  = "org.bitbucket"
+	serverTypePrefix = "org.bitbucket.server"
+)
+
+// CloudWebhook is the payload of Bitbucket Cloud webhook.
+type CloudWebhook struct {
+	Actor       CloudActor       `json:"actor"`
+	Repository  CloudRepository  `json:"repository"`
+	PullRequest CloudPullRequest `json:"pullrequest"`
+}
+
+type CloudActor struct {
+	Nickname string `json:"nickname"`
+	Username string `json:"username"`
+}
+
+type CloudRepository struct {
+	FullName string     `json:"full_name"`
+	Links    CloudLinks `json:"links"`
+}
+
+type CloudPullRequest struct {
+	Destination struct {
+		Repository CloudRepository `json:"repository"`
+	} `json:"destination"`
+}
+
+type CloudLinks struct {
+	HTML struct {
+		Href string `json:"href"`
+	} `json:"html"`
+}
+
+// ServerWebhook is the payload of Bitbucket Server webhook.
+type ServerWebhook struct {
+	Actor       ServerActor       `json:"actor"`
+	Repository  ServerRepository  `json:"repository"`
+	PullRequest ServerPullRequest `json:"pullRequest"`
+}
+
+type ServerActor struct {
+	Name string `json:"name"`
+}
+
+type ServerRepository struct {
+	Slug    string `json:"slug"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	Links struct {
+		Self []struct {
+			Href string `json:"href"`
+		} `json:"self"`
+	} `json:"links"`
+}
+
+type ServerPullRequest struct {
+	ToRef struct {
+		Repository ServerRepository `json:"repository"`
+	} `json:"toRef"`
+}
+
+func init() {
+	webhook.Register("bitbucket", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(opts.String("secret")), nil
+	})
+	webhook.Register("bitbucket-server", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewServerParser(opts.String("secret")), nil
+	})
+}
+
+type Parser struct {
+	secret []byte
+	server bool
+}
+
+// NewParser returns a new parser for Bitbucket Cloud.
+func NewParser(secret string) *Parser {
+	return &Parser{
+		secret: []byte(secret),
+	}
+}
+
+// NewServerParser returns a new parser for Bitbucket Server.
+func NewServerParser(secret string) *Parser {
+	return &Parser{
+		secret: []byte(secret),
+		server: true,
+	}
+}
+
+func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	if req.Body == nil {
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	payload, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// Only validate the signature if the secret is configured, as
+	// Bitbucket does not sign requests without the secret.
+	if len(p.secret) > 0 {
+		err = validateSignature(req.Header.Get("X-Hub-Signature"), payload, p.secret)
+		if err != nil {
+			return nil, webhook.Unauthorized(err)
+		}
+	}
+
+	key := req.Header.Get("X-Event-Key")
+	if key == "" {
+		return nil, webhook.BadRequest(errors.New("missing event key"))
+	}
+
+	if p.server {
+		return p.parseServer(req, key, payload)
+	}
+
+	return p.parseCloud(req, key, payload)
+}
+
+func (p *Parser) parseCloud(req *http.Request, key string, payload []byte) (*cloudevents.Event, error) {
+	var w CloudWebhook
+
+	if key != "repo:push" && !strings.HasPrefix(key, "pullrequest:") {
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported event key: %s", key))
+	}
+
+	err := json.Unmarshal(payload, &w)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	repo := w.Repository
+	if repo.Links.HTML.Href == "" {
+		repo = w.PullRequest.Destination.Repository
+	}
+
+	s, err := url.Parse(repo.Links.HTML.Href)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	ce := &cloudevents.Event{
+		ID:              req.Header.Get("X-Request-UUID"),
+		Type:            eventType(cloudTypePrefix, key),
+		Source:          *s,
+		DataContentType: "application/json",
+	}
+
+	actor := w.Actor.Nickname
+	if actor == "" {
+		actor = w.Actor.Username
+	}
+
+	ce.SetExtension("repository", repo.FullName)
+	ce.SetExtension("actor", actor)
+
+	return ce, nil
+}
+
+func (p *Parser) parseServer(req *http.Request, key string, payload []byte) (*cloudevents.Event, error) {
+	var w ServerWebhook
+
+	if key == "diagnostics:ping" {
+		// Ping is sent only to test the webhook configuration.
+		return nil, webhook.Ignored(errors.New("ping event"))
+	}
+
+	if key != "repo:refs_changed" && !strings.HasPrefix(key, "pr:") {
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported event key: %s", key))
+	}
+
+	err := json.Unmarshal(payload, &w)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	repo := w.Repository
+	if repo.Slug == "" {
+		repo = w.PullRequest.ToRef.Repository
+	}
+	if repo.Slug == "" {
+		return nil, webhook.BadRequest(errors.New("empty repository"))
+	}
+
+	// Use the relative path of the repository if the link to the
+	// repository is not available.
+	source := fmt.Sprintf("/projects/%s/repos/%s", repo.Project.Key, repo.Slug)
+	if len(repo.Links.Self) > 0 && repo.Links.Self[0].Href != "" {
+		source = repo.Links.Self[0].Href
+	}
+
+	s, err := url.Parse(source)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	ce := &cloudevents.Event{
+		ID:              req.Header.Get("X-Request-Id"),
+		Type:            eventType(serverTypePrefix, key),
+		Source:          *s,
+		DataContentType: "application/json",
+	}
+
+	ce.SetExtension("repository", fmt.Sprintf("%s/%s", repo.Project.Key, repo.Slug))
+	ce.SetExtension("actor", w.Actor.Name)
+
+	return ce, nil
+}
+
+// eventType returns the event type for the event key. For example,
+// "pullrequest:created" is converted to "<prefix>.pullrequest.created".
+func eventType(prefix, key string) string {
+	return fmt.Sprintf("%s.%s", prefix, strings.Replace(key, ":", ".", -1))
+}
+
+// validateSignature validates the HMAC-SHA256 signature of the payload
+// in X-Hub-Signature header.
+func validateSignature(signature string, payload, secret []byte) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errors.New("missing signature")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("payload signature check failed")
+	}
+
+	return nil
+}
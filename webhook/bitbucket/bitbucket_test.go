@@ -0,0 +1,143 @@
+package bitbucket
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	Secret      = "test"
+	EventID     = "3cd1f8a0-7b3e-4c1a-9a3e-5c0d2f1b8e77"
+	ContentType = "application/json"
+)
+
+func loadFixture(name string) ([]byte, error) {
+	_, fn, _, _ := runtime.Caller(0)
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	return ioutil.ReadFile(fx)
+}
+
+func getSignature(payload, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newRequest(name, key string) (*http.Request, error) {
+	body, err := loadFixture(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("X-Event-Key", key)
+	req.Header.Set("X-Request-UUID", EventID)
+	req.Header.Set("X-Request-Id", EventID)
+	req.Header.Set("X-Hub-Signature", getSignature(body, []byte(Secret)))
+
+	return req, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		server     bool
+		name       string
+		key        string
+		ceType     string
+		ceSource   string
+		repository string
+		actor      string
+	}{
+		{false, "cloud_repo_push", "repo:push", "org.bitbucket.repo.push", "https://bitbucket.org/emmap1/bitbucket-demo", "emmap1/bitbucket-demo", "emmap1"},
+		{false, "cloud_pullrequest", "pullrequest:created", "org.bitbucket.pullrequest.created", "https://bitbucket.org/emmap1/bitbucket-demo", "emmap1/bitbucket-demo", "emmap1"},
+		{false, "cloud_pullrequest", "pullrequest:fulfilled", "org.bitbucket.pullrequest.fulfilled", "https://bitbucket.org/emmap1/bitbucket-demo", "emmap1/bitbucket-demo", "emmap1"},
+		{true, "server_repo_refs_changed", "repo:refs_changed", "org.bitbucket.server.repo.refs_changed", "https://bitbucket.example.com/projects/PROJ/repos/repository/browse", "PROJ/repository", "admin"},
+		{true, "server_pr", "pr:opened", "org.bitbucket.server.pr.opened", "/projects/PROJ/repos/repository", "PROJ/repository", "admin"},
+		{true, "server_pr", "pr:merged", "org.bitbucket.server.pr.merged", "/projects/PROJ/repos/repository", "PROJ/repository", "admin"},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.name, test.key)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.key, err)
+		}
+
+		p := NewParser(Secret)
+		if test.server {
+			p = NewServerParser(Secret)
+		}
+
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.key, err)
+		}
+
+		if ce.ID != EventID {
+			t.Errorf("[%s] invalid ID: %v", test.key, ce.ID)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.key, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%s] invalid source: %v", test.key, ce.Source)
+		}
+		if ce.Extensions["repository"] != test.repository {
+			t.Errorf("[%s] invalid repository: %v", test.key, ce.Extensions["repository"])
+		}
+		if ce.Extensions["actor"] != test.actor {
+			t.Errorf("[%s] invalid actor: %v", test.key, ce.Extensions["actor"])
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		server bool
+		modify func(req *http.Request)
+		err    error
+	}{
+		{"signature", true, func(req *http.Request) { req.Header.Set("X-Hub-Signature", "sha256=00") }, webhook.ErrUnauthorized},
+		{"no-signature", true, func(req *http.Request) { req.Header.Del("X-Hub-Signature") }, webhook.ErrUnauthorized},
+		{"event-key", false, func(req *http.Request) { req.Header.Del("X-Event-Key") }, webhook.ErrBadRequest},
+		{"cloud-unknown", false, func(req *http.Request) { req.Header.Set("X-Event-Key", "issue:created") }, webhook.ErrUnsupportedEvent},
+		{"server-unknown", true, func(req *http.Request) { req.Header.Set("X-Event-Key", "repo:modified") }, webhook.ErrUnsupportedEvent},
+		{"server-ping", true, func(req *http.Request) { req.Header.Set("X-Event-Key", "diagnostics:ping") }, webhook.ErrIgnored},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest("server_pr", "pr:opened")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		test.modify(req)
+
+		p := NewParser(Secret)
+		if test.server {
+			p = NewServerParser(Secret)
+		}
+
+		_, err = p.Parse(req)
+		if !errors.Is(err, test.err) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
@@ -1,3 +1,17 @@
+// Package clair implements the parser for Clair webhook.
+//
+// Both the notification of Clair v2 and the notifier webhook of Clair
+// v4 are supported. Clair v4 only sends the ID and the callback URL of
+// the notification. If the parser is configured to fetch notifications,
+// the parser pages through the callback URL and converts each
+// notification to an event typed with its reason, such as
+// "com.coreos.clair.notification.added".
+//
+// The parser sets the following extension attributes if available.
+//
+//   - notification: Name or ID of the notification
+//   - vulnerability: Name of the vulnerability of the fetched
+//     notification
 package clair
 
 import (
@@ -6,29 +20,118 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	eventType              = "com.coreos.clair.notify"
+	notificationTypePrefix = "com.coreos.clair.notification"
+	contentType            = "application/json"
+
+	// fetchTimeout is the timeout to fetch a page of notifications.
+	fetchTimeout = 10 * time.Second
 )
 
 type Webhook struct {
 	Notification WebhookNotification `json:"Notification"`
+
+	// Fields of Clair v4.
+	NotificationID string `json:"notification_id"`
+	Callback       string `json:"callback"`
 }
 
 type WebhookNotification struct {
 	Name string `json:"Name"`
 }
 
-type Parser struct{}
+// NotificationPage is the page of notifications returned by the
+// callback URL of Clair v4.
+type NotificationPage struct {
+	Page struct {
+		Size int    `json:"size"`
+		Next string `json:"next"`
+	} `json:"page"`
+	Notifications []json.RawMessage `json:"notifications"`
+}
+
+// Notification is the notification of Clair v4.
+type Notification struct {
+	ID            string `json:"id"`
+	Manifest      string `json:"manifest"`
+	Reason        string `json:"reason"`
+	Vulnerability struct {
+		Name string `json:"name"`
+	} `json:"vulnerability"`
+}
+
+func init() {
+	webhook.Register("clair", func(opts webhook.Options) (webhook.Parser, error) {
+		var notifier *url.URL
+
+		if v := opts.String("notifierURL"); v != "" {
+			u, err := url.Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid notifier URL: %s", err)
+			}
+			if !u.IsAbs() || u.Host == "" {
+				return nil, fmt.Errorf("invalid notifier URL: %q", v)
+			}
+			notifier = u
+		}
+
+		// Callback URLs are fetched only under the notifier URL, so that
+		// the gateway cannot be used to send requests to other hosts.
+		fetch := opts.Bool("fetch")
+		if fetch && notifier == nil {
+			return nil, errors.New("notifierURL must be specified to fetch notifications")
+		}
 
-func NewParser() *Parser {
-	return &Parser{}
+		return NewParser(fetch, notifier), nil
+	})
 }
 
+type Parser struct {
+	fetch    bool
+	notifier *url.URL
+	client   *http.Client
+}
+
+// NewParser returns a new parser. If fetch is true, the parser fetches
+// the notifications of Clair v4 from the callback URL. Only callback
+// URLs under the notifier URL are fetched.
+func NewParser(fetch bool, notifier *url.URL) *Parser {
+	return &Parser{
+		fetch:    fetch,
+		notifier: notifier,
+		client:   &http.Client{Timeout: fetchTimeout},
+	}
+}
+
+// Parse returns the event of the notification, or the event of the
+// first fetched notification if the parser fetches notifications.
 func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	events, err := p.ParseAll(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, webhook.Ignored(errors.New("no notifications"))
+	}
+
+	return events[0], nil
+}
+
+func (p *Parser) ParseAll(req *http.Request) ([]*cloudevents.Event, error) {
 	var w Webhook
 
 	if req.Body == nil {
-		return nil, errors.New("empty payload")
+		return nil, webhook.BadRequest(errors.New("empty payload"))
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -36,20 +139,163 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 
 	err := decoder.Decode(&w)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
+	}
+
+	if w.NotificationID == "" {
+		ce, err := parseV2(&w)
+		if err != nil {
+			return nil, err
+		}
+		return []*cloudevents.Event{ce}, nil
+	}
+
+	s, err := url.Parse(w.Callback)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+	if !s.IsAbs() {
+		return nil, webhook.BadRequest(fmt.Errorf("invalid callback: %q", w.Callback))
+	}
+
+	if !p.fetch {
+		// The ID of the notification is unique to the notification.
+		ce := &cloudevents.Event{
+			ID:              w.NotificationID,
+			Type:            eventType,
+			Source:          *s,
+			DataContentType: contentType,
+		}
+
+		ce.SetExtension("notification", w.NotificationID)
+
+		return []*cloudevents.Event{ce}, nil
+	}
+
+	if !under(s, p.notifier) {
+		return nil, webhook.BadRequest(fmt.Errorf("callback is not under the notifier URL: %q", w.Callback))
+	}
+
+	notifications, err := p.fetchAll(s)
+	if err != nil {
+		return nil, webhook.Upstream(fmt.Errorf("unable to fetch notifications: %s", err))
+	}
+
+	events := []*cloudevents.Event{}
+	for _, data := range notifications {
+		var n Notification
+
+		err := json.Unmarshal(data, &n)
+		if err != nil {
+			return nil, webhook.Upstream(fmt.Errorf("invalid notification: %s", err))
+		}
+
+		ce := &cloudevents.Event{
+			ID:              n.ID,
+			Type:            fmt.Sprintf("%s.%s", notificationTypePrefix, n.Reason),
+			Source:          *s,
+			Subject:         n.Manifest,
+			DataContentType: contentType,
+			Data:            data,
+		}
+
+		ce.SetExtension("notification", w.NotificationID)
+		ce.SetExtension("vulnerability", n.Vulnerability.Name)
+
+		events = append(events, ce)
 	}
 
+	return events, nil
+}
+
+// under returns true if u has the same scheme and host as base, and
+// its path is base's path or below it.
+func under(u, base *url.URL) bool {
+	if base == nil {
+		return false
+	}
+	if u.Scheme != base.Scheme || !strings.EqualFold(u.Host, base.Host) {
+		return false
+	}
+
+	prefix := strings.TrimSuffix(base.Path, "/")
+	p := path.Clean("/" + u.Path)
+
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// parseV2 returns the event of the notification of Clair v2.
+func parseV2(w *Webhook) (*cloudevents.Event, error) {
 	source := fmt.Sprintf("/notifications/%s", w.Notification.Name)
 	s, err := url.Parse(source)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
+	// The name of the notification is unique to the notification.
 	ce := &cloudevents.Event{
-		Type:            "com.coreos.clair.notify",
+		ID:              w.Notification.Name,
+		Type:            eventType,
 		Source:          *s,
-		DataContentType: "application/json",
+		DataContentType: contentType,
 	}
 
+	ce.SetExtension("notification", w.Notification.Name)
+
 	return ce, nil
 }
+
+// fetchAll returns the notifications in all pages of the callback.
+func (p *Parser) fetchAll(callback *url.URL) ([]json.RawMessage, error) {
+	notifications := []json.RawMessage{}
+	seen := map[string]bool{}
+
+	next := ""
+	for {
+		page, err := p.fetchPage(callback, next)
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, page.Notifications...)
+
+		// The last page has no next page. The next page that has been
+		// fetched is also treated as the end to prevent an endless
+		// loop.
+		next = page.Page.Next
+		if next == "" || next == "-1" || seen[next] {
+			break
+		}
+		seen[next] = true
+	}
+
+	return notifications, nil
+}
+
+// fetchPage returns the page of notifications that begins at next.
+func (p *Parser) fetchPage(callback *url.URL, next string) (*NotificationPage, error) {
+	u := *callback
+	if next != "" {
+		q := u.Query()
+		q.Set("next", next)
+		u.RawQuery = q.Encode()
+	}
+
+	resp, err := p.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var page NotificationPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
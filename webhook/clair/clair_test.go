@@ -2,12 +2,19 @@ package clair
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 const (
@@ -32,7 +39,7 @@ func newRequest(name string) (*http.Request, error) {
 	}
 
 	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Content-Length", string(len(body)))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 
 	return req, nil
 }
@@ -43,16 +50,208 @@ func TestParse(t *testing.T) {
 		t.Fatalf("invalid request: %v", err)
 	}
 
-	p := NewParser()
+	p := NewParser(false, nil)
 	ce, err := p.Parse(req)
 	if err != nil {
 		t.Fatalf("parser error: %v", err)
 	}
 
+	if ce.ID != "6e4ad270-4957-4242-b5ad-dad851379573" {
+		t.Errorf("invalid ID: %v", ce.ID)
+	}
 	if ce.Type != "com.coreos.clair.notify" {
 		t.Errorf("invalid type: %v", ce.Type)
 	}
 	if ce.Source.String() != "/notifications/6e4ad270-4957-4242-b5ad-dad851379573" {
 		t.Errorf("invalid source: %v", ce.Source)
 	}
+	if ce.Extensions["notification"] != "6e4ad270-4957-4242-b5ad-dad851379573" {
+		t.Errorf("invalid notification: %v", ce.Extensions["notification"])
+	}
+}
+
+func TestParseV4(t *testing.T) {
+	req, err := newRequest("notify_v4")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	p := NewParser(false, nil)
+	ce, err := p.Parse(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	if ce.ID != "269886f3-0146-4f08-9bf7-cb1138d48643" {
+		t.Errorf("invalid ID: %v", ce.ID)
+	}
+	if ce.Type != "com.coreos.clair.notify" {
+		t.Errorf("invalid type: %v", ce.Type)
+	}
+	if ce.Source.String() != "http://clair-notifier/notifier/api/v1/notification/269886f3-0146-4f08-9bf7-cb1138d48643" {
+		t.Errorf("invalid source: %v", ce.Source)
+	}
+	if ce.Extensions["notification"] != "269886f3-0146-4f08-9bf7-cb1138d48643" {
+		t.Errorf("invalid notification: %v", ce.Extensions["notification"])
+	}
+}
+
+// newNotifier returns a test server that serves the pages of
+// notifications as the notifier of Clair v4.
+func newNotifier(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var name string
+		switch req.URL.Query().Get("next") {
+		case "":
+			name = "notifications_page1"
+		case "1b4d0db2-e757-4150-bbbb-543658144205":
+			name = "notifications_page2"
+		default:
+			t.Errorf("invalid next: %s", req.URL.Query().Get("next"))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, err := loadFixture(name)
+		if err != nil {
+			t.Errorf("invalid fixture: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		w.Write(body)
+	}))
+}
+
+func newFetchRequest(callback string) *http.Request {
+	body := fmt.Sprintf(`{"notification_id":"269886f3-0146-4f08-9bf7-cb1138d48643","callback":%q}`, callback)
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1", strings.NewReader(body))
+	req.Header.Set("Content-Type", ContentType)
+	return req
+}
+
+func TestParseFetch(t *testing.T) {
+	ts := newNotifier(t)
+	defer ts.Close()
+
+	callback := fmt.Sprintf("%s/notifier/api/v1/notification/269886f3-0146-4f08-9bf7-cb1138d48643", ts.URL)
+
+	notifier, _ := url.Parse(fmt.Sprintf("%s/notifier/api/v1/", ts.URL))
+
+	p := NewParser(true, notifier)
+	events, err := p.ParseAll(newFetchRequest(callback))
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	tests := []struct {
+		id            string
+		ceType        string
+		subject       string
+		vulnerability string
+	}{
+		{"5e4b387e-88d3-4364-86fd-063447a6fad2", "com.coreos.clair.notification.added", "sha256:35c102085707f703de2d9eaad8752d6fe1b8f02b5d2149f1d8357c9cc7fb7d0a", "CVE-2020-8161"},
+		{"8f3c2a1b-5d6e-4f70-8a9b-0c1d2e3f4a5b", "com.coreos.clair.notification.added", "sha256:9ba6ef8a2a1d0f2c4c10a3b6f5e4b1c0d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7", "CVE-2020-8161"},
+		{"1b4d0db2-e757-4150-bbbb-543658144205", "com.coreos.clair.notification.removed", "sha256:35c102085707f703de2d9eaad8752d6fe1b8f02b5d2149f1d8357c9cc7fb7d0a", "CVE-2019-16782"},
+	}
+
+	if len(events) != len(tests) {
+		t.Fatalf("invalid number of events: %d", len(events))
+	}
+
+	for i, test := range tests {
+		ce := events[i]
+		if ce.ID != test.id {
+			t.Errorf("[%d] invalid ID: %v", i, ce.ID)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%d] invalid type: %v", i, ce.Type)
+		}
+		if ce.Source.String() != callback {
+			t.Errorf("[%d] invalid source: %v", i, ce.Source)
+		}
+		if ce.Subject != test.subject {
+			t.Errorf("[%d] invalid subject: %v", i, ce.Subject)
+		}
+		if ce.Extensions["notification"] != "269886f3-0146-4f08-9bf7-cb1138d48643" {
+			t.Errorf("[%d] invalid notification: %v", i, ce.Extensions["notification"])
+		}
+		if ce.Extensions["vulnerability"] != test.vulnerability {
+			t.Errorf("[%d] invalid vulnerability: %v", i, ce.Extensions["vulnerability"])
+		}
+		if !strings.Contains(string(ce.Data), test.id) {
+			t.Errorf("[%d] invalid data: %s", i, ce.Data)
+		}
+	}
+}
+
+func TestParseFetchError(t *testing.T) {
+	notifier, _ := url.Parse("http://clair/notifier/api/v1")
+
+	// Callbacks outside of the notifier URL are not fetched.
+	callbacks := []string{
+		"http://clair.attacker.com/notifier/api/v1/notification/1",
+		"http://attacker.com/clair/notifier/api/v1/notification/1",
+		"http://clair@attacker.com/notifier/api/v1/notification/1",
+		"https://clair/notifier/api/v1/notification/1",
+		"http://clair/notifier/api/v10/notification/1",
+		"http://clair/notifier/api/v1/../../../admin",
+		"http://clair/admin",
+	}
+
+	p := NewParser(true, notifier)
+	for _, callback := range callbacks {
+		_, err := p.ParseAll(newFetchRequest(callback))
+		if !errors.Is(err, webhook.ErrBadRequest) {
+			t.Errorf("[%s] invalid error: %v", callback, err)
+		}
+	}
+
+	// Callbacks are not fetched without the notifier URL.
+	p = NewParser(true, nil)
+	_, err := p.ParseAll(newFetchRequest("http://clair/notifier/api/v1/notification/1"))
+	if !errors.Is(err, webhook.ErrBadRequest) {
+		t.Errorf("invalid error: %v", err)
+	}
+
+	// Errors of the notifier are upstream errors.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	notifier, _ = url.Parse(failing.URL)
+	p = NewParser(true, notifier)
+	_, err = p.ParseAll(newFetchRequest(fmt.Sprintf("%s/notifier/api/v1/notification/1", failing.URL)))
+	if !errors.Is(err, webhook.ErrUpstream) {
+		t.Errorf("invalid error: %v", err)
+	}
+	if webhook.StatusCode(err) != http.StatusBadGateway {
+		t.Errorf("invalid status: %d", webhook.StatusCode(err))
+	}
+}
+
+func TestNewParserOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts webhook.Options
+		err  bool
+	}{
+		{"no-fetch", webhook.Options{}, false},
+		{"fetch", webhook.Options{"fetch": true, "notifierURL": "http://clair/notifier/api/v1/"}, false},
+		{"fetch-without-notifier", webhook.Options{"fetch": true}, true},
+		{"relative-notifier", webhook.Options{"fetch": true, "notifierURL": "/notifier/api/v1/"}, true},
+		{"invalid-notifier", webhook.Options{"fetch": true, "notifierURL": "http://clair/%zz"}, true},
+	}
+
+	for _, test := range tests {
+		_, err := webhook.NewParser("clair", test.opts)
+		if test.err && err == nil {
+			t.Errorf("[%s] error expected", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("[%s] parser error: %v", test.name, err)
+		}
+	}
 }
@@ -1,3 +1,10 @@
+// Package dockerhub implements the parser for Docker Hub webhook.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - repository: Name of the repository
+//   - tag: Pushed tag
+//   - pusher: Name of the user who pushed the image
 package dockerhub
 
 import (
@@ -7,14 +14,29 @@ import (
 	"net/url"
 
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 type Webhook struct {
-	Repository WebhookRepository `json:"repository"`
+	CallbackURL string            `json:"callback_url"`
+	PushData    WebhookPushData   `json:"push_data"`
+	Repository  WebhookRepository `json:"repository"`
+}
+
+type WebhookPushData struct {
+	Pusher string `json:"pusher"`
+	Tag    string `json:"tag"`
 }
 
 type WebhookRepository struct {
-	RepoURL string `json:"repo_url"`
+	RepoName string `json:"repo_name"`
+	RepoURL  string `json:"repo_url"`
+}
+
+func init() {
+	webhook.Register("dockerhub", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(), nil
+	})
 }
 
 type Parser struct{}
@@ -27,7 +49,7 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 	var w Webhook
 
 	if req.Body == nil {
-		return nil, errors.New("empty payload")
+		return nil, webhook.BadRequest(errors.New("empty payload"))
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -35,19 +57,25 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 
 	err := decoder.Decode(&w)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
 	s, err := url.Parse(w.Repository.RepoURL)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
+	// The callback URL is unique to the push.
 	ce := &cloudevents.Event{
+		ID:              webhook.StableID(w.CallbackURL),
 		Type:            "com.docker.hub.push",
 		Source:          *s,
 		DataContentType: "application/json",
 	}
 
+	ce.SetExtension("repository", w.Repository.RepoName)
+	ce.SetExtension("tag", w.PushData.Tag)
+	ce.SetExtension("pusher", w.PushData.Pusher)
+
 	return ce, nil
 }
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -32,7 +34,7 @@ func newRequest(name string) (*http.Request, error) {
 	}
 
 	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Content-Length", string(len(body)))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 
 	return req, nil
 }
@@ -55,4 +57,64 @@ func TestParse(t *testing.T) {
 	if ce.Source.String() != "https://registry.hub.docker.com/u/svendowideit/testhook/" {
 		t.Errorf("invalid source: %v", ce.Source)
 	}
+	if ce.Extensions["repository"] != "svendowideit/testhook" {
+		t.Errorf("invalid repository: %v", ce.Extensions["repository"])
+	}
+	if ce.Extensions["tag"] != "latest" {
+		t.Errorf("invalid tag: %v", ce.Extensions["tag"])
+	}
+	if ce.Extensions["pusher"] != "trustedbuilder" {
+		t.Errorf("invalid pusher: %v", ce.Extensions["pusher"])
+	}
+}
+
+func TestParseStableID(t *testing.T) {
+	tests := []struct {
+		name     string
+		replacer *strings.Replacer
+		stable   bool
+	}{
+		{"redelivery", strings.NewReplacer(), true},
+		{"callback", strings.NewReplacer("2141b5bi5i5b02bec211i4eeih0242eg11000a", "3252c6cj6j6c13cfd322j5ffji1353fh22111b"), false},
+	}
+
+	body, err := loadFixture("push")
+	if err != nil {
+		t.Fatalf("invalid fixture: %v", err)
+	}
+
+	p := NewParser()
+	parse := func(body string) string {
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid request: %v", err)
+		}
+		req.Header.Set("Content-Type", ContentType)
+
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("parser error: %v", err)
+		}
+		return ce.ID
+	}
+
+	id := parse(string(body))
+	if id == "" {
+		t.Fatalf("ID must be set")
+	}
+
+	for _, test := range tests {
+		modified := test.replacer.Replace(string(body))
+		if !test.stable && modified == string(body) {
+			t.Fatalf("[%s] payload must be modified", test.name)
+		}
+
+		actual := parse(modified)
+		if test.stable && actual != id {
+			t.Errorf("[%s] ID must be stable: %s, %s", test.name, id, actual)
+		}
+		if !test.stable && actual == id {
+			t.Errorf("[%s] ID must differ: %s", test.name, actual)
+		}
+	}
 }
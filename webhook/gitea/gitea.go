@@ -0,0 +1,157 @@
+// Package gitea implements the parser for Gitea webhook. It also
+// accepts webhook of Forgejo and Gogs, which send the same payload with
+// their own header names.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - repository: Full name of the repository
+//   - sender: Login name of the user who triggered the event
+package gitea
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+// headerPrefixes is the list of prefixes of header names sent by Gitea
+// and its relatives.
+var headerPrefixes = []string{"X-Gitea-", "X-Forgejo-", "X-Gogs-"}
+
+type Webhook struct {
+	Repository WebhookRepository `json:"repository"`
+	Sender     WebhookUser       `json:"sender"`
+}
+
+type WebhookRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+	URL      string `json:"url"`
+}
+
+type WebhookUser struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+}
+
+func init() {
+	webhook.Register("gitea", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(opts.String("secret")), nil
+	})
+}
+
+type Parser struct {
+	secret []byte
+}
+
+func NewParser(secret string) *Parser {
+	return &Parser{
+		secret: []byte(secret),
+	}
+}
+
+func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	var w Webhook
+
+	if req.Body == nil {
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	payload, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// Only validate the signature if the secret is configured, as
+	// Gitea does not sign requests without the secret.
+	if len(p.secret) > 0 {
+		err = validateSignature(header(req, "Signature"), payload, p.secret)
+		if err != nil {
+			return nil, webhook.Unauthorized(err)
+		}
+	}
+
+	event := header(req, "Event")
+	if event == "" {
+		return nil, webhook.BadRequest(errors.New("missing event type"))
+	}
+
+	err = json.Unmarshal(payload, &w)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// Prefer the API URL of the repository as the GitHub parser does.
+	source := w.Repository.URL
+	if source == "" {
+		source = w.Repository.HTMLURL
+	}
+	if source == "" {
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported event type: %s", event))
+	}
+
+	s, err := url.Parse(source)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	ce := &cloudevents.Event{
+		ID:              header(req, "Delivery"),
+		Type:            fmt.Sprintf("io.gitea.%s", event),
+		Source:          *s,
+		DataContentType: "application/json",
+	}
+
+	sender := w.Sender.Login
+	if sender == "" {
+		sender = w.Sender.Username
+	}
+
+	ce.SetExtension("repository", w.Repository.FullName)
+	ce.SetExtension("sender", sender)
+
+	return ce, nil
+}
+
+// header returns the value of the header with the name, trying the
+// prefixes of Gitea, Forgejo and Gogs in order.
+func header(req *http.Request, name string) string {
+	for _, prefix := range headerPrefixes {
+		v := req.Header.Get(prefix + name)
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// validateSignature validates the hex-encoded HMAC-SHA256 signature of
+// the payload.
+func validateSignature(signature string, payload, secret []byte) error {
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("payload signature check failed")
+	}
+
+	return nil
+}
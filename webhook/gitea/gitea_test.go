@@ -0,0 +1,130 @@
+package gitea
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	Secret      = "test"
+	EventID     = "a0e1c8a2-3b6f-4d2e-9c1a-7f5e4d3c2b1a"
+	ContentType = "application/json"
+)
+
+func loadFixture(name string) ([]byte, error) {
+	_, fn, _, _ := runtime.Caller(0)
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	return ioutil.ReadFile(fx)
+}
+
+func getSignature(payload, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newRequest(name, prefix, event string) (*http.Request, error) {
+	body, err := loadFixture(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set(prefix+"Event", event)
+	req.Header.Set(prefix+"Delivery", EventID)
+	req.Header.Set(prefix+"Signature", getSignature(body, []byte(Secret)))
+
+	return req, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		event      string
+		ceType     string
+		ceSource   string
+		repository string
+		sender     string
+	}{
+		{"push", "X-Gitea-", "push", "io.gitea.push", "http://localhost:3000/api/v1/repos/gitea/webhooks", "gitea/webhooks", "gitea"},
+		{"pull_request", "X-Gitea-", "pull_request", "io.gitea.pull_request", "http://localhost:3000/api/v1/repos/gitea/webhooks", "gitea/webhooks", "alice"},
+		{"pull_request", "X-Forgejo-", "pull_request", "io.gitea.pull_request", "http://localhost:3000/api/v1/repos/gitea/webhooks", "gitea/webhooks", "alice"},
+		{"gogs_push", "X-Gogs-", "push", "io.gitea.push", "https://try.gogs.io/unknwon/webhooks", "unknwon/webhooks", "unknwon"},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.name, test.prefix, test.event)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+
+		p := NewParser(Secret)
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.name, err)
+		}
+
+		if ce.ID != EventID {
+			t.Errorf("[%s] invalid ID: %v", test.name, ce.ID)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.name, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%s] invalid source: %v", test.name, ce.Source)
+		}
+		if ce.Extensions["repository"] != test.repository {
+			t.Errorf("[%s] invalid repository: %v", test.name, ce.Extensions["repository"])
+		}
+		if ce.Extensions["sender"] != test.sender {
+			t.Errorf("[%s] invalid sender: %v", test.name, ce.Extensions["sender"])
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		modify  func(req *http.Request)
+		err     error
+	}{
+		{"signature", "push", func(req *http.Request) { req.Header.Set("X-Gitea-Signature", "00") }, webhook.ErrUnauthorized},
+		{"no-signature", "push", func(req *http.Request) { req.Header.Del("X-Gitea-Signature") }, webhook.ErrUnauthorized},
+		{"event-type", "push", func(req *http.Request) { req.Header.Del("X-Gitea-Event") }, webhook.ErrBadRequest},
+		{"no-repository", "organization", func(req *http.Request) { req.Header.Set("X-Gitea-Event", "organization") }, webhook.ErrUnsupportedEvent},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.fixture, "X-Gitea-", "push")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		test.modify(req)
+
+		p := NewParser(Secret)
+		_, err = p.Parse(req)
+		if !errors.Is(err, test.err) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
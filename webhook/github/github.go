@@ -1,58 +1,246 @@
+// Package github implements the parser for GitHub webhook.
+//
+// Event types that are not known to the parser are converted with the
+// URL of the repository as the source.
+//
+// The subject of the event is set per event type, such as the ref of
+// push events, the number of pull requests and issues, or the name of
+// workflows. If the "action" option is "type", the action of the
+// event is appended to the type, such as "com.github.pull_request.opened".
+//
+// The parser sets the following extension attributes if available.
+//
+//   - repository: Full name of the repository
+//   - sender: Login name of the user who triggered the event
+//   - action: Action of the event, such as opened or closed
+//   - enterprisehost: Host name of GitHub Enterprise Server
+//   - hooktargetid: ID of the resource where the webhook is created,
+//     such as the GitHub App
+//   - hooktargettype: Type of the resource where the webhook is created
+//   - installation: ID of the GitHub App installation
 package github
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/google/go-github/v29/github"
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+type payloadMeta struct {
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		URL      string `json:"url"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+
+	// Resources of the events that are not supported by go-github.
+	WorkflowRun     resource `json:"workflow_run"`
+	WorkflowJob     resource `json:"workflow_job"`
+	Package         resource `json:"package"`
+	RegistryPackage resource `json:"registry_package"`
+	Discussion      resource `json:"discussion"`
+	Alert           resource `json:"alert"`
+	MergeGroup      struct {
+		HeadRef string `json:"head_ref"`
+	} `json:"merge_group"`
+}
+
+type resource struct {
+	URL     string `json:"url"`
+	HTMLURL string `json:"html_url"`
+	Name    string `json:"name"`
+	Number  int    `json:"number"`
+}
+
+func init() {
+	webhook.Register("github", func(opts webhook.Options) (webhook.Parser, error) {
+		secrets, err := loadSecrets(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		apps, err := loadAppSecrets(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		p := NewParser(secrets...)
+		for id, secrets := range apps {
+			p.SetAppSecrets(id, secrets...)
+		}
+
+		switch action := opts.String("action"); action {
+		case "", ActionExtension:
+		case ActionType:
+			p.actionInType = true
+		default:
+			return nil, fmt.Errorf("invalid action mode: %s", action)
+		}
+
+		return p, nil
+	})
+}
+
+const (
+	// ActionExtension sets the action of the event only to the
+	// "action" extension attribute.
+	ActionExtension = "extension"
+	// ActionType also appends the action of the event to the type,
+	// such as "com.github.pull_request.opened".
+	ActionType = "type"
+)
+
+const (
+	enterpriseHostHeader = "X-GitHub-Enterprise-Host"
+	targetIDHeader       = "X-GitHub-Hook-Installation-Target-ID"
+	targetTypeHeader     = "X-GitHub-Hook-Installation-Target-Type"
 )
 
 type Parser struct {
-	secret []byte
+	secrets      [][]byte
+	appSecrets   map[string][][]byte
+	actionInType bool
 }
 
-func NewParser(secret string) *Parser {
+// NewParser returns a new parser that validates the signature of
+// requests with the secrets. A request is accepted if its signature
+// matches any of the secrets, so that the secret can be rotated
+// without failing deliveries. The signature is not validated if no
+// secrets are specified.
+func NewParser(secrets ...string) *Parser {
 	return &Parser{
-		secret: []byte(secret),
+		secrets:    toKeys(secrets),
+		appSecrets: map[string][][]byte{},
+	}
+}
+
+// SetAppSecrets sets the secrets used instead of the default secrets
+// for requests of the webhook whose installation target ID matches
+// id, such as the ID of a GitHub App. Once the secrets of any target
+// are set, requests of unknown targets are rejected unless the
+// default secrets are specified.
+func (p *Parser) SetAppSecrets(id string, secrets ...string) {
+	p.appSecrets[id] = toKeys(secrets)
+}
+
+// secretsFor returns the secrets to validate the request with.
+func (p *Parser) secretsFor(req *http.Request) ([][]byte, error) {
+	id := req.Header.Get(targetIDHeader)
+	if secrets := p.appSecrets[id]; id != "" && len(secrets) > 0 {
+		return secrets, nil
+	}
+
+	if len(p.secrets) == 0 && len(p.appSecrets) > 0 {
+		return nil, fmt.Errorf("unknown installation target: %q", id)
 	}
+
+	return p.secrets, nil
 }
 
 func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
-	var source string
+	var source, subject string
 
 	if req.Body == nil {
-		return nil, errors.New("empty payload")
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	ct := req.Header.Get("Content-Type")
+	switch ct {
+	case "application/json", "application/x-www-form-urlencoded":
+	default:
+		return nil, webhook.BadRequest(fmt.Errorf("unsupported content type: %q", ct))
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, webhook.BadRequest(err)
 	}
 
-	payload, err := github.ValidatePayload(req, p.secret)
+	secrets, err := p.secretsFor(req)
 	if err != nil {
-		return nil, err
+		return nil, webhook.Unauthorized(err)
+	}
+
+	if len(secrets) > 0 {
+		i, err := validateSignature(req.Header.Get(signature256Header), req.Header.Get(signatureHeader), body, secrets)
+		if err != nil {
+			return nil, webhook.Unauthorized(err)
+		}
+
+		// Log the matched secret to know when old secrets can be removed.
+		if len(secrets) > 1 {
+			log.Printf("event_id:%s secret_index:%d", github.DeliveryID(req), i)
+		}
+	}
+
+	payload, err := extractPayload(ct, body)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
 	}
 
 	webHookType := github.WebHookType(req)
+	if webHookType == "" {
+		return nil, webhook.BadRequest(errors.New("missing event type"))
+	}
+
+	var meta payloadMeta
+	err = json.Unmarshal(payload, &meta)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// Event types that go-github does not know are handled with the
+	// payload below.
 	event, err := github.ParseWebHook(webHookType, payload)
 	if err != nil {
-		return nil, err
+		var (
+			serr *json.SyntaxError
+			terr *json.UnmarshalTypeError
+		)
+		if errors.As(err, &serr) || errors.As(err, &terr) {
+			return nil, webhook.BadRequest(err)
+		}
 	}
 
 	switch event := event.(type) {
 	case *github.CheckRunEvent:
 		source = event.CheckRun.GetURL()
+		subject = event.CheckRun.GetName()
 	case *github.CheckSuiteEvent:
 		source = event.CheckSuite.GetURL()
+		subject = event.CheckSuite.GetHeadBranch()
 	case *github.CommitCommentEvent:
 		source = event.Comment.GetURL()
+		subject = event.Comment.GetCommitID()
 	case *github.CreateEvent:
 		source = event.Repo.GetURL()
+		subject = event.GetRef()
 	case *github.DeleteEvent:
 		source = event.Repo.GetURL()
+		subject = event.GetRef()
 	case *github.DeploymentEvent:
 		source = event.Deployment.GetURL()
+		subject = event.Deployment.GetEnvironment()
 	case *github.DeploymentStatusEvent:
 		source = event.Deployment.GetURL()
+		subject = event.Deployment.GetEnvironment()
 	case *github.ForkEvent:
 		source = event.Forkee.GetURL()
 	case *github.GollumEvent:
@@ -63,10 +251,13 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 		source = event.Installation.GetHTMLURL()
 	case *github.IssueCommentEvent:
 		source = event.Comment.GetURL()
+		subject = number(event.Issue.GetNumber())
 	case *github.IssuesEvent:
 		source = event.Issue.GetURL()
+		subject = number(event.Issue.GetNumber())
 	case *github.LabelEvent:
 		source = event.Label.GetURL()
+		subject = event.Label.GetName()
 	case *github.MarketplacePurchaseEvent:
 		source = event.Sender.GetURL()
 	case *github.MemberEvent:
@@ -75,12 +266,15 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 		source = event.Team.GetURL()
 	case *github.MilestoneEvent:
 		source = event.Milestone.GetURL()
+		subject = number(event.Milestone.GetNumber())
 	case *github.OrganizationEvent:
 		source = event.Organization.GetURL()
 	case *github.OrgBlockEvent:
 		source = event.Organization.GetURL()
 	case *github.PageBuildEvent:
 		source = event.Build.GetURL()
+	case *github.PingEvent:
+		source = event.Hook.GetURL()
 	case *github.ProjectCardEvent:
 		source = event.ProjectCard.GetURL()
 	case *github.ProjectColumnEvent:
@@ -91,10 +285,13 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 		source = event.Repo.GetURL()
 	case *github.PullRequestEvent:
 		source = event.PullRequest.GetURL()
+		subject = number(event.PullRequest.GetNumber())
 	case *github.PullRequestReviewEvent:
 		source = event.PullRequest.GetURL()
+		subject = number(event.PullRequest.GetNumber())
 	case *github.PullRequestReviewCommentEvent:
 		source = event.Comment.GetURL()
+		subject = number(event.PullRequest.GetNumber())
 	case *github.PushEvent:
 		// API URL is not set in "repository.url", need to generate URL from statuses URL.
 		base, err := url.Parse(event.Repo.GetStatusesURL())
@@ -106,35 +303,129 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 			return nil, err
 		}
 		source = base.ResolveReference(ref).String()
+		subject = event.GetRef()
 	case *github.ReleaseEvent:
 		source = event.Release.GetURL()
+		subject = event.Release.GetTagName()
 	case *github.RepositoryEvent:
 		source = event.Repo.GetURL()
+	case *github.RepositoryDispatchEvent:
+		source = event.Repo.GetURL()
 	case *github.StatusEvent:
 		source = event.Commit.GetURL()
+		subject = event.GetSHA()
 	case *github.TeamEvent:
 		source = event.Team.GetURL()
 	case *github.TeamAddEvent:
 		source = event.Team.GetURL()
 	case *github.WatchEvent:
 		source = event.Repo.GetURL()
+	default:
+		source = meta.resourceURL(webHookType)
+		subject = meta.subject(webHookType)
 	}
 
 	if source == "" {
-		return nil, errors.New("unsupported event type")
+		source = meta.Repository.URL
+	}
+	if source == "" {
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported event type: %s", webHookType))
 	}
 
 	s, err := url.Parse(source)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
+	}
+
+	ceType := fmt.Sprintf("com.github.%s", webHookType)
+	if p.actionInType && meta.Action != "" {
+		ceType = fmt.Sprintf("%s.%s", ceType, meta.Action)
 	}
 
 	ce := &cloudevents.Event{
 		ID:              github.DeliveryID(req),
-		Type:            fmt.Sprintf("com.github.%s", webHookType),
+		Type:            ceType,
 		Source:          *s,
+		Subject:         subject,
 		DataContentType: "application/json",
 	}
 
+	// The payload of form-encoded deliveries is extracted from the form,
+	// so that the data matches the content type.
+	if ct == "application/x-www-form-urlencoded" {
+		ce.Data = payload
+	}
+
+	ce.SetExtension("repository", meta.Repository.FullName)
+	ce.SetExtension("sender", meta.Sender.Login)
+	ce.SetExtension("action", meta.Action)
+	ce.SetExtension("enterprisehost", req.Header.Get(enterpriseHostHeader))
+	ce.SetExtension("hooktargetid", req.Header.Get(targetIDHeader))
+	ce.SetExtension("hooktargettype", req.Header.Get(targetTypeHeader))
+	if meta.Installation.ID != 0 {
+		ce.SetExtension("installation", strconv.FormatInt(meta.Installation.ID, 10))
+	}
+
 	return ce, nil
 }
+
+// resourceURL returns the URL of the resource of the events that are
+// not supported by go-github. It returns an empty string if the event
+// type is unknown.
+func (m *payloadMeta) resourceURL(eventType string) string {
+	switch eventType {
+	case "workflow_run":
+		return m.WorkflowRun.URL
+	case "workflow_job":
+		return m.WorkflowJob.URL
+	case "package":
+		return m.Package.HTMLURL
+	case "registry_package":
+		return m.RegistryPackage.HTMLURL
+	case "discussion":
+		return m.Discussion.HTMLURL
+	case "code_scanning_alert", "secret_scanning_alert", "dependabot_alert":
+		return m.Alert.URL
+	case "merge_group":
+		// Same as push event, the source is the URL of the ref.
+		if m.Repository.URL != "" && m.MergeGroup.HeadRef != "" {
+			return fmt.Sprintf("%s/git/%s", m.Repository.URL, m.MergeGroup.HeadRef)
+		}
+	}
+
+	return ""
+}
+
+// subject returns the subject of the events that are not supported by
+// go-github.
+func (m *payloadMeta) subject(eventType string) string {
+	switch eventType {
+	case "workflow_run":
+		return m.WorkflowRun.Name
+	case "workflow_job":
+		return m.WorkflowJob.Name
+	case "workflow_dispatch":
+		return m.Ref
+	case "package":
+		return m.Package.Name
+	case "registry_package":
+		return m.RegistryPackage.Name
+	case "discussion":
+		return number(m.Discussion.Number)
+	case "code_scanning_alert", "secret_scanning_alert", "dependabot_alert":
+		return number(m.Alert.Number)
+	case "merge_group":
+		return m.MergeGroup.HeadRef
+	}
+
+	return ""
+}
+
+// number returns the number as a string, or an empty string if the
+// number is not set.
+func number(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
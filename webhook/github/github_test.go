@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 const (
@@ -31,6 +38,12 @@ func getSignature(payload, secret []byte) string {
 	return fmt.Sprintf("sha1=%s", hex.EncodeToString(mac.Sum(nil)))
 }
 
+func getSignature256(payload, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
 func newRequest(name string) (*http.Request, error) {
 	body, err := loadFixture(name)
 	if err != nil {
@@ -43,7 +56,7 @@ func newRequest(name string) (*http.Request, error) {
 	}
 
 	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Content-Length", string(len(body)))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 	req.Header.Set("X-GitHub-Event", name)
 	req.Header.Set("X-GitHub-Delivery", EventID)
 	req.Header.Set("X-Hub-Signature", getSignature(body, []byte(Secret)))
@@ -92,6 +105,19 @@ func TestParse(t *testing.T) {
 		{"team", "com.github.team", "https://api.github.com/teams/2723476"},
 		{"team_add", "com.github.team_add", "https://api.github.com/teams/2723476"},
 		{"watch", "com.github.watch", "https://api.github.com/repos/Codertocat/Hello-World"},
+		{"workflow_run", "com.github.workflow_run", "https://api.github.com/repos/Codertocat/Hello-World/actions/runs/30433642"},
+		{"workflow_job", "com.github.workflow_job", "https://api.github.com/repos/Codertocat/Hello-World/actions/jobs/2832853555"},
+		{"workflow_dispatch", "com.github.workflow_dispatch", "https://api.github.com/repos/Codertocat/Hello-World"},
+		{"repository_dispatch", "com.github.repository_dispatch", "https://api.github.com/repos/Codertocat/Hello-World"},
+		{"package", "com.github.package", "https://github.com/Codertocat/hello-world-npm/packages/10696"},
+		{"registry_package", "com.github.registry_package", "https://github.com/Codertocat/hello-world-npm/packages/10696"},
+		{"discussion", "com.github.discussion", "https://github.com/Codertocat/Hello-World/discussions/90"},
+		{"code_scanning_alert", "com.github.code_scanning_alert", "https://api.github.com/repos/Codertocat/Hello-World/code-scanning/alerts/10"},
+		{"secret_scanning_alert", "com.github.secret_scanning_alert", "https://api.github.com/repos/Codertocat/Hello-World/secret-scanning/alerts/5"},
+		{"dependabot_alert", "com.github.dependabot_alert", "https://api.github.com/repos/Codertocat/Hello-World/dependabot/alerts/2"},
+		{"merge_group", "com.github.merge_group", "https://api.github.com/repos/Codertocat/Hello-World/git/refs/heads/gh-readonly-queue/main/pr-2-e3103f8eb03e1ad7f2331c5446b23c070fc54055"},
+		{"ping", "com.github.ping", "https://api.github.com/repos/Codertocat/Hello-World/hooks/109948940"},
+		{"branch_protection_rule", "com.github.branch_protection_rule", "https://api.github.com/repos/Codertocat/Hello-World"},
 	}
 
 	for _, test := range tests {
@@ -114,3 +140,380 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		eventType  string
+		repository string
+		sender     string
+	}{
+		{"push", "Codertocat/Hello-World", "Codertocat"},
+		{"installation", "", "octocat"},
+		{"workflow_run", "Codertocat/Hello-World", "Codertocat"},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.eventType)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.eventType, err)
+		}
+
+		p := NewParser(Secret)
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.eventType, err)
+		}
+
+		if ce.Extensions["repository"] != test.repository {
+			t.Errorf("[%s] invalid repository: %v", test.eventType, ce.Extensions["repository"])
+		}
+		if ce.Extensions["sender"] != test.sender {
+			t.Errorf("[%s] invalid sender: %v", test.eventType, ce.Extensions["sender"])
+		}
+	}
+}
+
+func TestParseEnterprise(t *testing.T) {
+	req, err := newRequest("installation")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+	req.Header.Set("X-GitHub-Enterprise-Host", "github.example.com")
+	req.Header.Set("X-GitHub-Hook-Installation-Target-ID", "123")
+	req.Header.Set("X-GitHub-Hook-Installation-Target-Type", "integration")
+
+	p := NewParser(Secret)
+	ce, err := p.Parse(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	expected := map[string]string{
+		"enterprisehost": "github.example.com",
+		"hooktargetid":   "123",
+		"hooktargettype": "integration",
+		"installation":   "2",
+	}
+	for name, value := range expected {
+		if ce.Extensions[name] != value {
+			t.Errorf("invalid %s: %v", name, ce.Extensions[name])
+		}
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		eventType string
+		ceSubject string
+	}{
+		{"push", "refs/tags/simple-tag"},
+		{"create", "simple-tag"},
+		{"delete", "simple-tag"},
+		{"pull_request", "1"},
+		{"pull_request_review", "1"},
+		{"issues", "2"},
+		{"issue_comment", "2"},
+		{"check_run", "randscape"},
+		{"release", "0.0.1"},
+		{"workflow_run", "Build"},
+		{"workflow_job", "test"},
+		{"discussion", "90"},
+		{"dependabot_alert", "2"},
+		{"ping", ""},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.eventType)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.eventType, err)
+		}
+
+		p := NewParser(Secret)
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.eventType, err)
+		}
+
+		if ce.Subject != test.ceSubject {
+			t.Errorf("[%s] invalid subject: %v", test.eventType, ce.Subject)
+		}
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		eventType    string
+		actionInType bool
+		ceType       string
+		action       string
+	}{
+		{"pull_request", false, "com.github.pull_request", "closed"},
+		{"pull_request", true, "com.github.pull_request.closed", "closed"},
+		{"workflow_run", true, "com.github.workflow_run.completed", "completed"},
+		{"push", true, "com.github.push", ""},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.eventType)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.eventType, err)
+		}
+
+		p := NewParser(Secret)
+		p.actionInType = test.actionInType
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.eventType, err)
+		}
+
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.eventType, ce.Type)
+		}
+		if ce.Extensions["action"] != test.action {
+			t.Errorf("[%s] invalid action: %v", test.eventType, ce.Extensions["action"])
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(req *http.Request)
+		err    error
+	}{
+		{"signature", func(req *http.Request) { req.Header.Set("X-Hub-Signature", "sha1=invalid") }, webhook.ErrUnauthorized},
+		{"content-type", func(req *http.Request) { req.Header.Set("Content-Type", "text/plain") }, webhook.ErrBadRequest},
+		{"event-type", func(req *http.Request) { req.Header.Del("X-GitHub-Event") }, webhook.ErrBadRequest},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest("push")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		test.modify(req)
+
+		p := NewParser(Secret)
+		_, err = p.Parse(req)
+		if !errors.Is(err, test.err) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
+
+func TestParseUnknown(t *testing.T) {
+	// Events without the repository can not be converted.
+	req, err := newRequest("organization")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+	req.Header.Set("X-GitHub-Event", "unknown")
+
+	p := NewParser(Secret)
+	_, err = p.Parse(req)
+	if !errors.Is(err, webhook.ErrUnsupportedEvent) {
+		t.Errorf("invalid error: %v", err)
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	payload, err := loadFixture("push")
+	if err != nil {
+		t.Fatalf("invalid fixture: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("payload", string(payload))
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-GitHub-Delivery", EventID)
+	req.Header.Set("X-Hub-Signature-256", getSignature256(body, []byte(Secret)))
+
+	p := NewParser(Secret)
+	ce, err := p.Parse(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	if ce.DataContentType != "application/json" {
+		t.Errorf("invalid data content type: %v", ce.DataContentType)
+	}
+	if !bytes.Equal(ce.Data, payload) {
+		t.Errorf("invalid data: %s", ce.Data)
+	}
+	if ce.Type != "com.github.push" {
+		t.Errorf("invalid type: %v", ce.Type)
+	}
+
+	// JSON deliveries are forwarded as they are.
+	req, err = newRequest("push")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	ce, err = p.Parse(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+	if ce.Data != nil {
+		t.Errorf("data must not be set: %s", ce.Data)
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	body, err := loadFixture("push")
+	if err != nil {
+		t.Fatalf("invalid fixture: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		secrets []string
+		sig1    string
+		sig256  string
+		err     error
+	}{
+		{"sha1", []string{Secret}, getSignature(body, []byte(Secret)), "", nil},
+		{"sha256", []string{Secret}, "", getSignature256(body, []byte(Secret)), nil},
+		{"prefer-sha256", []string{Secret}, "sha1=invalid", getSignature256(body, []byte(Secret)), nil},
+		{"invalid-sha256", []string{Secret}, getSignature(body, []byte(Secret)), getSignature256(body, []byte("invalid")), webhook.ErrUnauthorized},
+		{"rotation-old", []string{"new", Secret}, "", getSignature256(body, []byte(Secret)), nil},
+		{"rotation-new", []string{Secret, "new"}, "", getSignature256(body, []byte("new")), nil},
+		{"rotation-invalid", []string{Secret, "new"}, "", getSignature256(body, []byte("other")), webhook.ErrUnauthorized},
+		{"missing", []string{Secret}, "", "", webhook.ErrUnauthorized},
+		{"no-secret", nil, "", "", nil},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest("push")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		req.Header.Set("X-Hub-Signature", test.sig1)
+		req.Header.Set("X-Hub-Signature-256", test.sig256)
+
+		p := NewParser(test.secrets...)
+		_, err = p.Parse(req)
+		if test.err == nil && err != nil {
+			t.Errorf("[%s] parser error: %v", test.name, err)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
+
+func TestParseAppSecrets(t *testing.T) {
+	body, err := loadFixture("push")
+	if err != nil {
+		t.Fatalf("invalid fixture: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		secrets  []string
+		targetID string
+		secret   string
+		err      error
+	}{
+		{"app1", nil, "1", "app1", nil},
+		{"app2", nil, "2", "app2", nil},
+		{"other-app-secret", nil, "1", "app2", webhook.ErrUnauthorized},
+		{"unknown-target", nil, "3", Secret, webhook.ErrUnauthorized},
+		{"missing-target", nil, "", Secret, webhook.ErrUnauthorized},
+		{"default", []string{Secret}, "3", Secret, nil},
+		{"default-for-app", []string{Secret}, "1", Secret, webhook.ErrUnauthorized},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest("push")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		req.Header.Set("X-Hub-Signature", getSignature(body, []byte(test.secret)))
+		req.Header.Set("X-GitHub-Hook-Installation-Target-ID", test.targetID)
+
+		p := NewParser(test.secrets...)
+		p.SetAppSecrets("1", "app1")
+		p.SetAppSecrets("2", "app2")
+		_, err = p.Parse(req)
+		if test.err == nil && err != nil {
+			t.Errorf("[%s] parser error: %v", test.name, err)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("from-file\n")
+	f.Close()
+
+	os.Setenv("GITHUB_TEST_SECRET", "from-env")
+	defer os.Unsetenv("GITHUB_TEST_SECRET")
+
+	opts := webhook.Options{
+		"secret":      "plain",
+		"secrets":     []interface{}{"old", "new"},
+		"secretFiles": []interface{}{f.Name()},
+		"secretEnvs":  "GITHUB_TEST_SECRET",
+	}
+
+	secrets, err := loadSecrets(opts)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+
+	expected := []string{"plain", "old", "new", "from-file", "from-env"}
+	if fmt.Sprint(secrets) != fmt.Sprint(expected) {
+		t.Errorf("invalid secrets: %v", secrets)
+	}
+
+	_, err = loadSecrets(webhook.Options{"secretEnvs": "GITHUB_TEST_UNDEFINED"})
+	if err == nil {
+		t.Errorf("undefined environment variable must be an error")
+	}
+}
+
+func TestLoadAppSecrets(t *testing.T) {
+	os.Setenv("GITHUB_TEST_APP_SECRET", "from-env")
+	defer os.Unsetenv("GITHUB_TEST_APP_SECRET")
+
+	opts := webhook.Options{
+		"appSecrets": map[interface{}]interface{}{
+			123: "plain",
+			456: []interface{}{"old", "new"},
+			789: map[interface{}]interface{}{
+				"secretEnvs": "GITHUB_TEST_APP_SECRET",
+			},
+		},
+	}
+
+	apps, err := loadAppSecrets(opts)
+	if err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+
+	expected := map[string][]string{
+		"123": {"plain"},
+		"456": {"old", "new"},
+		"789": {"from-env"},
+	}
+	if fmt.Sprint(apps) != fmt.Sprint(expected) {
+		t.Errorf("invalid secrets: %v", apps)
+	}
+}
@@ -0,0 +1,138 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	signatureHeader    = "X-Hub-Signature"
+	signature256Header = "X-Hub-Signature-256"
+)
+
+// loadSecrets returns the secrets in the options. Secrets are specified
+// as plain values with "secret" or "secrets", as files with
+// "secretFiles", or as environment variables with "secretEnvs".
+func loadSecrets(opts webhook.Options) ([]string, error) {
+	secrets := []string{}
+	secrets = append(secrets, opts.Strings("secret")...)
+	secrets = append(secrets, opts.Strings("secrets")...)
+
+	for _, p := range opts.Strings("secretFiles") {
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secret file: %s", err)
+		}
+		secrets = append(secrets, strings.TrimSpace(string(buf)))
+	}
+
+	for _, name := range opts.Strings("secretEnvs") {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("secret environment variable is not set: %s", name)
+		}
+		secrets = append(secrets, v)
+	}
+
+	return secrets, nil
+}
+
+// loadAppSecrets returns the secrets of each installation target in
+// "appSecrets" option. The secrets of a target are specified as plain
+// values, or as options in the same format as loadSecrets.
+func loadAppSecrets(opts webhook.Options) (map[string][]string, error) {
+	apps := map[string][]string{}
+
+	targets := opts.Map("appSecrets")
+	for id := range targets {
+		sub := targets.Map(id)
+		if sub == nil {
+			apps[id] = targets.Strings(id)
+			continue
+		}
+
+		secrets, err := loadSecrets(sub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", id, err)
+		}
+		apps[id] = secrets
+	}
+
+	return apps, nil
+}
+
+// toKeys returns the non-empty secrets as HMAC keys.
+func toKeys(secrets []string) [][]byte {
+	keys := [][]byte{}
+	for _, secret := range secrets {
+		if secret != "" {
+			keys = append(keys, []byte(secret))
+		}
+	}
+
+	return keys
+}
+
+// extractPayload returns the JSON payload in the request body.
+func extractPayload(contentType string, body []byte) ([]byte, error) {
+	if contentType != "application/x-www-form-urlencoded" {
+		return body, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(form.Get("payload")), nil
+}
+
+// validateSignature validates the signature of the body with the
+// secrets, and returns the index of the matched secret. The SHA-256
+// signature is used if it is present, otherwise the SHA-1 signature.
+func validateSignature(sig256, sig1 string, body []byte, secrets [][]byte) (int, error) {
+	var (
+		signature string
+		prefix    string
+		hashFunc  func() hash.Hash
+	)
+
+	switch {
+	case sig256 != "":
+		signature, prefix, hashFunc = sig256, "sha256=", sha256.New
+	case sig1 != "":
+		signature, prefix, hashFunc = sig1, "sha1=", sha1.New
+	default:
+		return -1, errors.New("missing signature")
+	}
+
+	if !strings.HasPrefix(signature, prefix) {
+		return -1, errors.New("invalid signature")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return -1, errors.New("invalid signature")
+	}
+
+	for i, secret := range secrets {
+		mac := hmac.New(hashFunc, secret)
+		mac.Write(body)
+		if hmac.Equal(sig, mac.Sum(nil)) {
+			return i, nil
+		}
+	}
+
+	return -1, errors.New("payload signature check failed")
+}
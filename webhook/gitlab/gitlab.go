@@ -0,0 +1,138 @@
+// Package gitlab implements the parser for GitLab webhook.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - project: Path of the project with its namespace
+//   - user: Username of the user who triggered the event
+package gitlab
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+// eventTypes maps the value of X-Gitlab-Event header to the event type.
+var eventTypes = map[string]string{
+	"Push Hook":          "com.gitlab.push",
+	"Tag Push Hook":      "com.gitlab.tag_push",
+	"Merge Request Hook": "com.gitlab.merge_request",
+	"Pipeline Hook":      "com.gitlab.pipeline",
+	"Job Hook":           "com.gitlab.job",
+	"Note Hook":          "com.gitlab.note",
+	"Issue Hook":         "com.gitlab.issue",
+	"Release Hook":       "com.gitlab.release",
+	"Deployment Hook":    "com.gitlab.deployment",
+}
+
+type Webhook struct {
+	Project      WebhookProject    `json:"project"`
+	Repository   WebhookRepository `json:"repository"`
+	User         WebhookUser       `json:"user"`
+	UserUsername string            `json:"user_username"`
+}
+
+type WebhookProject struct {
+	WebURL            string `json:"web_url"`
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+type WebhookRepository struct {
+	Homepage string `json:"homepage"`
+}
+
+type WebhookUser struct {
+	Username string `json:"username"`
+}
+
+func init() {
+	webhook.Register("gitlab", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(opts.String("token")), nil
+	})
+}
+
+type Parser struct {
+	token []byte
+}
+
+func NewParser(token string) *Parser {
+	return &Parser{
+		token: []byte(token),
+	}
+}
+
+func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	var w Webhook
+
+	if req.Body == nil {
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	// Only verify the token if it is configured, as GitLab does not
+	// send the header without the secret token.
+	if len(p.token) > 0 {
+		token := []byte(req.Header.Get("X-Gitlab-Token"))
+		if subtle.ConstantTimeCompare(token, p.token) != 1 {
+			return nil, webhook.Unauthorized(errors.New("token mismatch"))
+		}
+	}
+
+	event := req.Header.Get("X-Gitlab-Event")
+	if event == "" {
+		return nil, webhook.BadRequest(errors.New("missing event type"))
+	}
+
+	eventType, ok := eventTypes[event]
+	if !ok {
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported event type: %s", event))
+	}
+
+	payload, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	err = json.Unmarshal(payload, &w)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// Job events of older GitLab do not have the project.
+	source := w.Project.WebURL
+	if source == "" {
+		source = w.Repository.Homepage
+	}
+	if source == "" {
+		return nil, webhook.BadRequest(errors.New("empty project URL"))
+	}
+
+	s, err := url.Parse(source)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	ce := &cloudevents.Event{
+		ID:              req.Header.Get("X-Gitlab-Event-UUID"),
+		Type:            eventType,
+		Source:          *s,
+		DataContentType: "application/json",
+	}
+
+	user := w.User.Username
+	if user == "" {
+		user = w.UserUsername
+	}
+
+	ce.SetExtension("project", w.Project.PathWithNamespace)
+	ce.SetExtension("user", user)
+
+	return ce, nil
+}
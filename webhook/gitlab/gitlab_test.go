@@ -0,0 +1,124 @@
+package gitlab
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	Token       = "test"
+	EventID     = "9f1b2c36-4f8f-4b52-8e0c-2b5c5a1e0c41"
+	ContentType = "application/json"
+)
+
+func loadFixture(name string) ([]byte, error) {
+	_, fn, _, _ := runtime.Caller(0)
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	return ioutil.ReadFile(fx)
+}
+
+func newRequest(name, event string) (*http.Request, error) {
+	body, err := loadFixture(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("X-Gitlab-Event", event)
+	req.Header.Set("X-Gitlab-Event-UUID", EventID)
+	req.Header.Set("X-Gitlab-Token", Token)
+
+	return req, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		ceType   string
+		ceSource string
+		project  string
+		user     string
+	}{
+		{"push", "Push Hook", "com.gitlab.push", "http://example.com/mike/diaspora", "mike/diaspora", "jsmith"},
+		{"tag_push", "Tag Push Hook", "com.gitlab.tag_push", "http://example.com/jsmith/example", "jsmith/example", "jsmith"},
+		{"merge_request", "Merge Request Hook", "com.gitlab.merge_request", "http://example.com/gitlabhq/gitlab-test", "gitlabhq/gitlab-test", "root"},
+		{"pipeline", "Pipeline Hook", "com.gitlab.pipeline", "http://192.168.64.1:3005/gitlab-org/gitlab-test", "gitlab-org/gitlab-test", "root"},
+		{"job", "Job Hook", "com.gitlab.job", "http://192.168.64.1:3005/gitlab-org/gitlab-test", "", "user"},
+		{"note", "Note Hook", "com.gitlab.note", "http://example.com/gitlabhq/gitlab-test", "gitlabhq/gitlab-test", "root"},
+		{"issue", "Issue Hook", "com.gitlab.issue", "http://example.com/gitlabhq/gitlab-test", "gitlabhq/gitlab-test", "root"},
+		{"release", "Release Hook", "com.gitlab.release", "https://example.com/gitlab-org/release-webhook-example", "gitlab-org/release-webhook-example", ""},
+		{"deployment", "Deployment Hook", "com.gitlab.deployment", "http://10.126.0.2:3000/root/test-deployment-webhooks", "root/test-deployment-webhooks", "root"},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.name, test.event)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+
+		p := NewParser(Token)
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.name, err)
+		}
+
+		if ce.ID != EventID {
+			t.Errorf("[%s] invalid ID: %v", test.name, ce.ID)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.name, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%s] invalid source: %v", test.name, ce.Source)
+		}
+		if ce.Extensions["project"] != test.project {
+			t.Errorf("[%s] invalid project: %v", test.name, ce.Extensions["project"])
+		}
+		if ce.Extensions["user"] != test.user {
+			t.Errorf("[%s] invalid user: %v", test.name, ce.Extensions["user"])
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(req *http.Request)
+		err    error
+	}{
+		{"token", func(req *http.Request) { req.Header.Set("X-Gitlab-Token", "invalid") }, webhook.ErrUnauthorized},
+		{"no-token", func(req *http.Request) { req.Header.Del("X-Gitlab-Token") }, webhook.ErrUnauthorized},
+		{"event-type", func(req *http.Request) { req.Header.Del("X-Gitlab-Event") }, webhook.ErrBadRequest},
+		{"unknown", func(req *http.Request) { req.Header.Set("X-Gitlab-Event", "Wiki Page Hook") }, webhook.ErrUnsupportedEvent},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest("push", "Push Hook")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		test.modify(req)
+
+		p := NewParser(Token)
+		_, err = p.Parse(req)
+		if !errors.Is(err, test.err) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
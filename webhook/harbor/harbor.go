@@ -0,0 +1,177 @@
+// Package harbor implements the parser for Harbor webhook.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - repository: Full name of the repository
+//   - tag: Tag of the artifact
+//   - operator: Name of the user or the job that triggered the event
+package harbor
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+// eventTypes maps the type of the payload to the event type.
+var eventTypes = map[string]string{
+	"PUSH_ARTIFACT":      "io.goharbor.push_artifact",
+	"PULL_ARTIFACT":      "io.goharbor.pull_artifact",
+	"DELETE_ARTIFACT":    "io.goharbor.delete_artifact",
+	"SCANNING_COMPLETED": "io.goharbor.scanning_completed",
+	"SCANNING_FAILED":    "io.goharbor.scanning_failed",
+	"QUOTA_EXCEED":       "io.goharbor.quota_exceed",
+	"REPLICATION":        "io.goharbor.replication",
+}
+
+type Webhook struct {
+	Type      string           `json:"type"`
+	OccurAt   int64            `json:"occur_at"`
+	Operator  string           `json:"operator"`
+	EventData WebhookEventData `json:"event_data"`
+}
+
+type WebhookEventData struct {
+	Resources   []WebhookResource   `json:"resources"`
+	Repository  WebhookRepository   `json:"repository"`
+	Replication *WebhookReplication `json:"replication"`
+}
+
+type WebhookResource struct {
+	Digest      string `json:"digest"`
+	Tag         string `json:"tag"`
+	ResourceURL string `json:"resource_url"`
+}
+
+type WebhookRepository struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	RepoFullName string `json:"repo_full_name"`
+}
+
+type WebhookReplication struct {
+	SrcResource struct {
+		Endpoint  string `json:"endpoint"`
+		Namespace string `json:"namespace"`
+	} `json:"src_resource"`
+}
+
+func init() {
+	webhook.Register("harbor", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(opts.String("authorization")), nil
+	})
+}
+
+type Parser struct {
+	authorization []byte
+}
+
+// NewParser returns a new parser. If authorization is not empty, the
+// parser requires Authorization header of requests to match it.
+func NewParser(authorization string) *Parser {
+	return &Parser{
+		authorization: []byte(authorization),
+	}
+}
+
+func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	var w Webhook
+
+	if req.Body == nil {
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	if len(p.authorization) > 0 {
+		auth := []byte(req.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, p.authorization) != 1 {
+			return nil, webhook.Unauthorized(errors.New("authorization mismatch"))
+		}
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	defer req.Body.Close()
+
+	err := decoder.Decode(&w)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	eventType, ok := eventTypes[w.Type]
+	if !ok {
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported event type: %s", w.Type))
+	}
+
+	var resource WebhookResource
+	if len(w.EventData.Resources) > 0 {
+		resource = w.EventData.Resources[0]
+	}
+
+	repo := w.EventData.Repository
+	if repo.RepoFullName == "" && repo.Name != "" {
+		repo.RepoFullName = fmt.Sprintf("%s/%s", repo.Namespace, repo.Name)
+	}
+
+	var source string
+	switch {
+	case resource.ResourceURL != "":
+		source = fmt.Sprintf("//%s", repositoryURL(resource.ResourceURL))
+	case repo.RepoFullName != "":
+		source = fmt.Sprintf("/%s", repo.RepoFullName)
+	case w.EventData.Replication != nil && w.EventData.Replication.SrcResource.Endpoint != "":
+		source = w.EventData.Replication.SrcResource.Endpoint
+	default:
+		return nil, webhook.BadRequest(errors.New("empty resource"))
+	}
+
+	s, err := url.Parse(source)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	subject := resource.Digest
+	if subject == "" {
+		subject = resource.Tag
+	}
+
+	ce := &cloudevents.Event{
+		Type:            eventType,
+		Source:          *s,
+		Subject:         subject,
+		DataContentType: "application/json",
+	}
+
+	if w.OccurAt > 0 {
+		t := time.Unix(w.OccurAt, 0)
+		ce.Time = &t
+	}
+
+	ce.SetExtension("repository", repo.RepoFullName)
+	ce.SetExtension("tag", resource.Tag)
+	ce.SetExtension("operator", w.Operator)
+
+	return ce, nil
+}
+
+// repositoryURL returns the URL of the repository from the URL of the
+// resource by removing its tag or digest. For example,
+// "harbor.example.com/library/nginx:latest" is converted to
+// "harbor.example.com/library/nginx".
+func repositoryURL(resourceURL string) string {
+	if i := strings.Index(resourceURL, "@"); i >= 0 {
+		return resourceURL[:i]
+	}
+
+	if i := strings.LastIndex(resourceURL, ":"); i > strings.LastIndex(resourceURL, "/") {
+		return resourceURL[:i]
+	}
+
+	return resourceURL
+}
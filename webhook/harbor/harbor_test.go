@@ -0,0 +1,121 @@
+package harbor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	Authorization = "Bearer test"
+	ContentType   = "application/json"
+	Digest        = "sha256:8a9e9863dbb6e10edb5adfe917c00da84e1700fa76e7ed02476aa6e6fb8ee0d8"
+)
+
+func loadFixture(name string) ([]byte, error) {
+	_, fn, _, _ := runtime.Caller(0)
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	return ioutil.ReadFile(fx)
+}
+
+func newRequest(name string) (*http.Request, error) {
+	body, err := loadFixture(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("Authorization", Authorization)
+
+	return req, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		ceType     string
+		ceSource   string
+		ceSubject  string
+		repository string
+		tag        string
+	}{
+		{"push_artifact", "io.goharbor.push_artifact", "//hub.harbor.com/test-webhook/debian", Digest, "test-webhook/debian", "latest"},
+		{"pull_artifact", "io.goharbor.pull_artifact", "//hub.harbor.com/test-webhook/debian", Digest, "test-webhook/debian", ""},
+		{"delete_artifact", "io.goharbor.delete_artifact", "//hub.harbor.com/test-webhook/debian", Digest, "test-webhook/debian", "latest"},
+		{"scanning_completed", "io.goharbor.scanning_completed", "//hub.harbor.com/test-webhook/debian", Digest, "test-webhook/debian", "latest"},
+		{"scanning_failed", "io.goharbor.scanning_failed", "//hub.harbor.com/test-webhook/debian", Digest, "test-webhook/debian", "latest"},
+		{"quota_exceed", "io.goharbor.quota_exceed", "/library/busybox", "sha256:c4ff2e4c3cb2f3e4e5b4a1f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d9", "library/busybox", ""},
+		{"replication", "io.goharbor.replication", "https://hub.harbor.com", "", "", ""},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.name)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+
+		p := NewParser(Authorization)
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.name, err)
+		}
+
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.name, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%s] invalid source: %v", test.name, ce.Source.String())
+		}
+		if ce.Subject != test.ceSubject {
+			t.Errorf("[%s] invalid subject: %v", test.name, ce.Subject)
+		}
+		if ce.Time == nil {
+			t.Errorf("[%s] time must be set", test.name)
+		}
+		if ce.Extensions["repository"] != test.repository {
+			t.Errorf("[%s] invalid repository: %v", test.name, ce.Extensions["repository"])
+		}
+		if ce.Extensions["tag"] != test.tag {
+			t.Errorf("[%s] invalid tag: %v", test.name, ce.Extensions["tag"])
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	req, err := newRequest("push_artifact")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer invalid")
+
+	p := NewParser(Authorization)
+	_, err = p.Parse(req)
+	if !errors.Is(err, webhook.ErrUnauthorized) {
+		t.Errorf("invalid error: %v", err)
+	}
+
+	req, err = newRequest("push_artifact")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"type":"TAG_RETENTION"}`)))
+
+	_, err = p.Parse(req)
+	if !errors.Is(err, webhook.ErrUnsupportedEvent) {
+		t.Errorf("invalid error: %v", err)
+	}
+}
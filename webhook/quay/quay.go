@@ -0,0 +1,158 @@
+// Package quay implements the parser for Quay repository notifications.
+//
+// Quay does not send the kind of the notification in the request, so
+// the parser infers it from the payload. Notifications of queued,
+// started and successful builds have the same payload, so they are
+// typed as "io.quay.build" unless the kind is configured for the route.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - repository: Full name of the repository
+//   - vulnerability: ID of the found vulnerability
+package quay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	KindRepoPush           = "repo_push"
+	KindBuildQueued        = "build_queued"
+	KindBuildStart         = "build_start"
+	KindBuildSuccess       = "build_success"
+	KindBuildFailure       = "build_failure"
+	KindVulnerabilityFound = "vulnerability_found"
+
+	// kindBuild is the kind of build notifications whose status can
+	// not be inferred from the payload.
+	kindBuild = "build"
+)
+
+var kinds = map[string]bool{
+	KindRepoPush:           true,
+	KindBuildQueued:        true,
+	KindBuildStart:         true,
+	KindBuildSuccess:       true,
+	KindBuildFailure:       true,
+	KindVulnerabilityFound: true,
+}
+
+type Webhook struct {
+	Repository    string                `json:"repository"`
+	Homepage      string                `json:"homepage"`
+	UpdatedTags   []string              `json:"updated_tags"`
+	DockerTags    []string              `json:"docker_tags"`
+	Tags          []string              `json:"tags"`
+	BuildID       string                `json:"build_id"`
+	ErrorMessage  *string               `json:"error_message"`
+	Vulnerability *WebhookVulnerability `json:"vulnerability"`
+}
+
+type WebhookVulnerability struct {
+	ID       string `json:"id"`
+	Priority string `json:"priority"`
+}
+
+func init() {
+	webhook.Register("quay", func(opts webhook.Options) (webhook.Parser, error) {
+		kind := opts.String("kind")
+		if kind != "" && !kinds[kind] {
+			return nil, fmt.Errorf("invalid notification kind: %s", kind)
+		}
+		return NewParser(kind), nil
+	})
+}
+
+type Parser struct {
+	kind string
+}
+
+// NewParser returns a new parser. If kind is empty, the kind of the
+// notification is inferred from the payload.
+func NewParser(kind string) *Parser {
+	return &Parser{
+		kind: kind,
+	}
+}
+
+func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	var w Webhook
+
+	if req.Body == nil {
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	defer req.Body.Close()
+
+	err := decoder.Decode(&w)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	kind := p.kind
+	if kind == "" {
+		kind = inferKind(&w)
+	}
+	if kind == "" {
+		return nil, webhook.UnsupportedEvent(errors.New("unknown notification"))
+	}
+
+	if w.Homepage == "" {
+		return nil, webhook.BadRequest(errors.New("empty homepage"))
+	}
+
+	s, err := url.Parse(w.Homepage)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	var tags []string
+	switch {
+	case len(w.UpdatedTags) > 0:
+		tags = w.UpdatedTags
+	case len(w.DockerTags) > 0:
+		tags = w.DockerTags
+	default:
+		tags = w.Tags
+	}
+
+	ce := &cloudevents.Event{
+		Type:            fmt.Sprintf("io.quay.%s", kind),
+		Source:          *s,
+		Subject:         strings.Join(tags, ","),
+		DataContentType: "application/json",
+	}
+
+	ce.SetExtension("repository", w.Repository)
+	if w.Vulnerability != nil {
+		ce.SetExtension("vulnerability", w.Vulnerability.ID)
+	}
+
+	return ce, nil
+}
+
+// inferKind returns the kind of the notification from the shape of the
+// payload.
+func inferKind(w *Webhook) string {
+	switch {
+	case w.Vulnerability != nil:
+		return KindVulnerabilityFound
+	case w.BuildID != "" && w.ErrorMessage != nil:
+		return KindBuildFailure
+	case w.BuildID != "":
+		return kindBuild
+	case w.UpdatedTags != nil:
+		return KindRepoPush
+	}
+
+	return ""
+}
@@ -0,0 +1,102 @@
+package quay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	ContentType = "application/json"
+)
+
+func loadFixture(name string) ([]byte, error) {
+	_, fn, _, _ := runtime.Caller(0)
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	return ioutil.ReadFile(fx)
+}
+
+func newRequest(name string) (*http.Request, error) {
+	body, err := loadFixture(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return req, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		kind          string
+		ceType        string
+		ceSource      string
+		ceSubject     string
+		vulnerability string
+	}{
+		{"repo_push", "", "io.quay.repo_push", "https://quay.io/repository/mynamespace/repository", "latest,v1", ""},
+		{"build_queued", "", "io.quay.build", "https://quay.io/repository/mynamespace/repository/build/296ec063-5f86-4706-a469-f0a400bf9df2", "latest,foo,bar", ""},
+		{"build_queued", KindBuildQueued, "io.quay.build_queued", "https://quay.io/repository/mynamespace/repository/build/296ec063-5f86-4706-a469-f0a400bf9df2", "latest,foo,bar", ""},
+		{"build_queued", KindBuildSuccess, "io.quay.build_success", "https://quay.io/repository/mynamespace/repository/build/296ec063-5f86-4706-a469-f0a400bf9df2", "latest,foo,bar", ""},
+		{"build_failure", "", "io.quay.build_failure", "https://quay.io/repository/mynamespace/repository/build/296ec063-5f86-4706-a469-f0a400bf9df2", "latest,foo,bar", ""},
+		{"vulnerability_found", "", "io.quay.vulnerability_found", "https://quay.io/repository/mynamespace/repository", "latest,othertag", "CVE-1234-5678"},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.name)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+
+		p := NewParser(test.kind)
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.name, err)
+		}
+
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.name, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%s] invalid source: %v", test.name, ce.Source.String())
+		}
+		if ce.Subject != test.ceSubject {
+			t.Errorf("[%s] invalid subject: %v", test.name, ce.Subject)
+		}
+		if ce.Extensions["repository"] != "mynamespace/repository" {
+			t.Errorf("[%s] invalid repository: %v", test.name, ce.Extensions["repository"])
+		}
+		if ce.Extensions["vulnerability"] != test.vulnerability {
+			t.Errorf("[%s] invalid vulnerability: %v", test.name, ce.Extensions["vulnerability"])
+		}
+	}
+}
+
+func TestParseUnknown(t *testing.T) {
+	req, err := newRequest("unknown")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	p := NewParser("")
+	_, err = p.Parse(req)
+	if !errors.Is(err, webhook.ErrUnsupportedEvent) {
+		t.Errorf("invalid error: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// Package registry implements the parser for notifications of Docker
+// Registry v2 (distribution). A notification is an envelope of events
+// and each of them is converted to a CloudEvent.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - repository: Name of the repository
+//   - tag: Tag of the manifest
+//   - actor: Name of the user who triggered the event
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+// actions is the list of supported actions. Events of other actions
+// in the envelope are skipped.
+var actions = map[string]bool{
+	"push":   true,
+	"pull":   true,
+	"delete": true,
+}
+
+type Envelope struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+type Event struct {
+	ID        string      `json:"id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Action    string      `json:"action"`
+	Target    EventTarget `json:"target"`
+	Request   struct {
+		Host string `json:"host"`
+	} `json:"request"`
+	Actor struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+}
+
+type EventTarget struct {
+	Digest     string `json:"digest"`
+	Repository string `json:"repository"`
+	URL        string `json:"url"`
+	Tag        string `json:"tag"`
+}
+
+func init() {
+	webhook.Register("registry", func(opts webhook.Options) (webhook.Parser, error) {
+		return NewParser(), nil
+	})
+}
+
+type Parser struct{}
+
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// Parse returns the first event in the envelope.
+func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	events, err := p.ParseAll(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, webhook.Ignored(errors.New("no events"))
+	}
+
+	return events[0], nil
+}
+
+// ParseAll returns the events in the envelope.
+func (p *Parser) ParseAll(req *http.Request) ([]*cloudevents.Event, error) {
+	var env Envelope
+
+	if req.Body == nil {
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	defer req.Body.Close()
+
+	err := decoder.Decode(&env)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	events := []*cloudevents.Event{}
+	for _, raw := range env.Events {
+		var e Event
+
+		err := json.Unmarshal(raw, &e)
+		if err != nil {
+			return nil, webhook.BadRequest(err)
+		}
+
+		if !actions[e.Action] {
+			continue
+		}
+
+		s, err := url.Parse(source(&e))
+		if err != nil {
+			return nil, webhook.BadRequest(err)
+		}
+
+		ce := &cloudevents.Event{
+			ID:              e.ID,
+			Type:            fmt.Sprintf("org.distribution.registry.%s", e.Action),
+			Source:          *s,
+			Subject:         e.Target.Digest,
+			DataContentType: "application/json",
+			Data:            []byte(raw),
+		}
+
+		if !e.Timestamp.IsZero() {
+			t := e.Timestamp
+			ce.Time = &t
+		}
+
+		ce.SetExtension("repository", e.Target.Repository)
+		ce.SetExtension("tag", e.Target.Tag)
+		ce.SetExtension("actor", e.Actor.Name)
+
+		events = append(events, ce)
+	}
+
+	return events, nil
+}
+
+// source returns the URL of the repository of the event. The URL is
+// derived from the URL of the target, or the host of the request if
+// the target does not have it.
+func source(e *Event) string {
+	path := fmt.Sprintf("/v2/%s", e.Target.Repository)
+
+	u, err := url.Parse(e.Target.URL)
+	if err == nil && u.Host != "" {
+		return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, path)
+	}
+
+	if e.Request.Host != "" {
+		return fmt.Sprintf("//%s%s", e.Request.Host, path)
+	}
+
+	return path
+}
@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
+)
+
+const (
+	ContentType = "application/vnd.docker.distribution.events.v1+json"
+	Digest      = "sha256:fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf"
+)
+
+func loadFixture(name string) ([]byte, error) {
+	_, fn, _, _ := runtime.Caller(0)
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	return ioutil.ReadFile(fx)
+}
+
+func newRequest(name string) (*http.Request, error) {
+	body, err := loadFixture(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return req, nil
+}
+
+func TestParseAll(t *testing.T) {
+	tests := []struct {
+		ceID      string
+		ceType    string
+		ceSource  string
+		ceSubject string
+		tag       string
+		actor     string
+	}{
+		{"320678d8-ca14-430f-8bb6-4ca139cd83f7", "org.distribution.registry.pull", "http://192.168.100.227:5000/v2/hello-world", Digest, "latest", ""},
+		{"a7c1b2d3-e4f5-4a6b-8c7d-9e0f1a2b3c4d", "org.distribution.registry.push", "http://192.168.100.227:5000/v2/hello-world", Digest, "v1", "alice"},
+		{"9c8b7a6f-5e4d-4c3b-a2a1-0f9e8d7c6b5a", "org.distribution.registry.delete", "//192.168.100.227:5000/v2/hello-world", Digest, "", "admin"},
+	}
+
+	req, err := newRequest("events")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	p := NewParser()
+	events, err := p.ParseAll(req)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+
+	if len(events) != len(tests) {
+		t.Fatalf("invalid number of events: %d", len(events))
+	}
+
+	for i, test := range tests {
+		ce := events[i]
+
+		if ce.ID != test.ceID {
+			t.Errorf("[%d] invalid ID: %v", i, ce.ID)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%d] invalid type: %v", i, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%d] invalid source: %v", i, ce.Source.String())
+		}
+		if ce.Subject != test.ceSubject {
+			t.Errorf("[%d] invalid subject: %v", i, ce.Subject)
+		}
+		if ce.Time == nil || ce.Time.Year() != 2016 {
+			t.Errorf("[%d] invalid time: %v", i, ce.Time)
+		}
+		if ce.Extensions["repository"] != "hello-world" {
+			t.Errorf("[%d] invalid repository: %v", i, ce.Extensions["repository"])
+		}
+		if ce.Extensions["tag"] != test.tag {
+			t.Errorf("[%d] invalid tag: %v", i, ce.Extensions["tag"])
+		}
+		if ce.Extensions["actor"] != test.actor {
+			t.Errorf("[%d] invalid actor: %v", i, ce.Extensions["actor"])
+		}
+
+		var data Event
+		err := json.Unmarshal(ce.Data, &data)
+		if err != nil {
+			t.Errorf("[%d] invalid data: %v", i, err)
+		}
+		if data.ID != test.ceID {
+			t.Errorf("[%d] data must be the event: %s", i, ce.Data)
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1", strings.NewReader(`{"events":[]}`))
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	p := NewParser()
+	_, err = p.Parse(req)
+	if !errors.Is(err, webhook.ErrIgnored) {
+		t.Errorf("invalid error: %v", err)
+	}
+}
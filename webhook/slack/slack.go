@@ -1,45 +1,178 @@
+// Package slack implements the parser for Slack slash commands, Events
+// API and interactivity.
+//
+// The url_verification request of Events API is answered by the parser
+// and is not forwarded to the backend.
+//
+// The parser sets the following extension attributes if available.
+//
+//   - team: Domain of the workspace, or its ID for Events API
+//   - channel: Name of the channel, or its ID for Events API
+//   - user: Name of the user who triggered the event, or its ID for
+//     Events API
 package slack
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 const (
 	eventType   = "com.slack.slash_command"
 	contentType = "application/x-www-form-urlencoded"
+
+	eventTypePrefix       = "com.slack.event"
+	interactionTypePrefix = "com.slack.interaction"
+
+	// defaultReplayWindow is the maximum age of requests accepted by
+	// default, as recommended by Slack.
+	defaultReplayWindow = 5 * time.Minute
 )
 
-type Parser struct{}
+func init() {
+	webhook.Register("slack", func(opts webhook.Options) (webhook.Parser, error) {
+		var window time.Duration
+
+		if v := opts.String("replayWindow"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid replay window: %s", err)
+			}
+			window = d
+		}
+
+		// Unsigned requests are only accepted if explicitly allowed.
+		secret := opts.String("secret")
+		if secret == "" {
+			if !opts.Bool("insecure") {
+				return nil, errors.New("secret must be specified unless insecure is enabled")
+			}
+			log.Printf("slack: signature verification is disabled")
+		}
+
+		return NewParser(secret, window), nil
+	})
+}
+
+// EventCallback is the request of Events API.
+type EventCallback struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	TeamID    string `json:"team_id"`
+	APIAppID  string `json:"api_app_id"`
+	EventID   string `json:"event_id"`
+	EventTime int64  `json:"event_time"`
+	Event     struct {
+		Type    string `json:"type"`
+		User    string `json:"user"`
+		Channel string `json:"channel"`
+	} `json:"event"`
+}
+
+// Interaction is the payload of interactive requests.
+type Interaction struct {
+	Type      string `json:"type"`
+	APIAppID  string `json:"api_app_id"`
+	TriggerID string `json:"trigger_id"`
+	Team      struct {
+		ID     string `json:"id"`
+		Domain string `json:"domain"`
+	} `json:"team"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+type Parser struct {
+	secret []byte
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewParser returns a new parser that verifies requests with the
+// signing secret of the Slack app. Requests older than the window are
+// rejected to prevent replay attacks. The default window is used if
+// window is zero.
+func NewParser(secret string, window time.Duration) *Parser {
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
 
-func NewParser() *Parser {
-	return &Parser{}
+	return &Parser{
+		secret: []byte(secret),
+		window: window,
+		now:    time.Now,
+	}
 }
 
 func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 	if req.Body == nil {
-		return nil, errors.New("empty payload")
+		return nil, webhook.BadRequest(errors.New("empty payload"))
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// Only verify the signature if the signing secret is configured.
+	if len(p.secret) > 0 {
+		err = p.verify(req.Header, body)
+		if err != nil {
+			return nil, webhook.Unauthorized(err)
+		}
+	}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return p.parseEvent(body)
 	}
 
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
 	req.ParseForm()
-	defer req.Body.Close()
 
+	if payload := req.FormValue("payload"); payload != "" {
+		return p.parseInteraction([]byte(payload))
+	}
+
+	return p.parseCommand(req)
+}
+
+// parseCommand returns the event of the slash command.
+func (p *Parser) parseCommand(req *http.Request) (*cloudevents.Event, error) {
 	command := req.FormValue("command")
 	if command == "" {
-		return nil, errors.New("enpty command")
+		return nil, webhook.BadRequest(errors.New("empty command"))
 	}
 
 	tid := req.FormValue("trigger_id")
 	if tid == "" {
-		return nil, errors.New("empty trigger ID")
+		return nil, webhook.BadRequest(errors.New("empty trigger ID"))
 	}
 
 	s, err := url.Parse(command)
 	if err != nil {
-		return nil, err
+		return nil, webhook.BadRequest(err)
 	}
 
 	ce := &cloudevents.Event{
@@ -49,5 +182,148 @@ func (p *Parser) Parse(req *http.Request) (*cloudevents.Event, error) {
 		DataContentType: contentType,
 	}
 
+	ce.SetExtension("team", req.FormValue("team_domain"))
+	ce.SetExtension("channel", req.FormValue("channel_name"))
+	ce.SetExtension("user", req.FormValue("user_name"))
+
+	return ce, nil
+}
+
+// parseEvent returns the event of Events API.
+func (p *Parser) parseEvent(body []byte) (*cloudevents.Event, error) {
+	var cb EventCallback
+
+	err := json.Unmarshal(body, &cb)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	switch cb.Type {
+	case "url_verification":
+		return nil, &webhook.Reply{
+			StatusCode:  http.StatusOK,
+			ContentType: "text/plain",
+			Body:        []byte(cb.Challenge),
+		}
+	case "app_rate_limited":
+		return nil, webhook.Ignored(errors.New("app rate limited"))
+	case "event_callback":
+	default:
+		return nil, webhook.UnsupportedEvent(fmt.Errorf("unsupported request type: %s", cb.Type))
+	}
+
+	if cb.Event.Type == "" {
+		return nil, webhook.BadRequest(errors.New("empty event type"))
+	}
+
+	s, err := url.Parse(fmt.Sprintf("/apps/%s", cb.APIAppID))
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	ce := &cloudevents.Event{
+		ID:              cb.EventID,
+		Type:            fmt.Sprintf("%s.%s", eventTypePrefix, cb.Event.Type),
+		Source:          *s,
+		Subject:         subject(cb.TeamID, cb.Event.Channel),
+		DataContentType: "application/json",
+	}
+
+	if cb.EventTime > 0 {
+		t := time.Unix(cb.EventTime, 0)
+		ce.Time = &t
+	}
+
+	ce.SetExtension("team", cb.TeamID)
+	ce.SetExtension("channel", cb.Event.Channel)
+	ce.SetExtension("user", cb.Event.User)
+
 	return ce, nil
 }
+
+// parseInteraction returns the event of the interactive payload.
+func (p *Parser) parseInteraction(payload []byte) (*cloudevents.Event, error) {
+	var in Interaction
+
+	err := json.Unmarshal(payload, &in)
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	if in.Type == "" {
+		return nil, webhook.BadRequest(errors.New("empty interaction type"))
+	}
+
+	s, err := url.Parse(fmt.Sprintf("/apps/%s", in.APIAppID))
+	if err != nil {
+		return nil, webhook.BadRequest(err)
+	}
+
+	// The payload is forwarded as the data instead of the form.
+	ce := &cloudevents.Event{
+		ID:              in.TriggerID,
+		Type:            fmt.Sprintf("%s.%s", interactionTypePrefix, in.Type),
+		Source:          *s,
+		Subject:         subject(in.Team.ID, in.Channel.ID),
+		DataContentType: "application/json",
+		Data:            payload,
+	}
+
+	user := in.User.Username
+	if user == "" {
+		user = in.User.ID
+	}
+
+	ce.SetExtension("team", in.Team.Domain)
+	ce.SetExtension("channel", in.Channel.Name)
+	ce.SetExtension("user", user)
+
+	return ce, nil
+}
+
+// subject returns the subject of the event in "<team>/<channel>"
+// format, or the team if the channel is empty.
+func subject(team, channel string) string {
+	if channel == "" {
+		return team
+	}
+	return fmt.Sprintf("%s/%s", team, channel)
+}
+
+// verify verifies X-Slack-Signature header of the request with the
+// signing secret, and checks that the request is within the window.
+func (p *Parser) verify(header http.Header, body []byte) error {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	if ts == "" {
+		return errors.New("missing timestamp")
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+
+	age := p.now().Sub(time.Unix(sec, 0))
+	if age > p.window || age < -p.window {
+		return fmt.Errorf("timestamp is out of the window: %s", ts)
+	}
+
+	signature := header.Get("X-Slack-Signature")
+	if !strings.HasPrefix(signature, "v0=") {
+		return errors.New("missing signature")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "v0="))
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	mac := hmac.New(sha256.New, p.secret)
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("payload signature check failed")
+	}
+
+	return nil
+}
@@ -2,26 +2,46 @@ package slack
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/webhook"
 )
 
 const (
+	Secret      = "test"
+	Timestamp   = 1531420618
 	ContentType = "application/x-www-form-urlencoded"
 )
 
-func loadFixture(name string) ([]byte, error) {
+// loadFixture loads the fixture of the name. Fixtures of Events API
+// are JSON files and others are form-encoded text files.
+func loadFixture(name string) ([]byte, string, error) {
 	_, fn, _, _ := runtime.Caller(0)
-	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.txt", name))
-	return ioutil.ReadFile(fx)
+
+	fx := filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.json", name))
+	body, err := ioutil.ReadFile(fx)
+	if err == nil {
+		return body, "application/json", nil
+	}
+
+	fx = filepath.Join(filepath.Dir(fn), "fixtures", fmt.Sprintf("%s.txt", name))
+	body, err = ioutil.ReadFile(fx)
+	return body, ContentType, err
 }
 
 func newRequest(name string) (*http.Request, error) {
-	body, err := loadFixture(name)
+	body, contentType, err := loadFixture(name)
 	if err != nil {
 		return nil, err
 	}
@@ -31,19 +51,38 @@ func newRequest(name string) (*http.Request, error) {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", ContentType)
-	req.Header.Set("Content-Length", string(len(body)))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("X-Slack-Request-Timestamp", strconv.Itoa(Timestamp))
+	req.Header.Set("X-Slack-Signature", getSignature(body, []byte(Secret), Timestamp))
 
 	return req, nil
 }
 
+func getSignature(body, secret []byte, ts int) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "v0:%d:", ts)
+	mac.Write(body)
+	return fmt.Sprintf("v0=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+// newParser returns a parser whose clock is set to the timestamp of
+// the test requests.
+func newParser() *Parser {
+	p := NewParser(Secret, 0)
+	p.now = func() time.Time {
+		return time.Unix(Timestamp, 0).Add(time.Minute)
+	}
+	return p
+}
+
 func TestParse(t *testing.T) {
 	req, err := newRequest("slash_command")
 	if err != nil {
 		t.Fatalf("invalid request: %v", err)
 	}
 
-	p := NewParser()
+	p := newParser()
 	ce, err := p.Parse(req)
 	if err != nil {
 		t.Fatalf("parser error: %v", err)
@@ -55,4 +94,144 @@ func TestParse(t *testing.T) {
 	if ce.Source.String() != "/weather" {
 		t.Errorf("invalid source: %v", ce.Source)
 	}
+	if ce.Extensions["team"] != "example" {
+		t.Errorf("invalid team: %v", ce.Extensions["team"])
+	}
+	if ce.Extensions["channel"] != "test" {
+		t.Errorf("invalid channel: %v", ce.Extensions["channel"])
+	}
+	if ce.Extensions["user"] != "Steve" {
+		t.Errorf("invalid user: %v", ce.Extensions["user"])
+	}
+}
+
+func TestParseSignatureError(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(req *http.Request)
+	}{
+		{"signature", func(req *http.Request) { req.Header.Set("X-Slack-Signature", "v0=00") }},
+		{"no-signature", func(req *http.Request) { req.Header.Del("X-Slack-Signature") }},
+		{"no-timestamp", func(req *http.Request) { req.Header.Del("X-Slack-Request-Timestamp") }},
+		{"replay", func(req *http.Request) {
+			body, _, _ := loadFixture("slash_command")
+			ts := Timestamp - 600
+			req.Header.Set("X-Slack-Request-Timestamp", strconv.Itoa(ts))
+			req.Header.Set("X-Slack-Signature", getSignature(body, []byte(Secret), ts))
+		}},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest("slash_command")
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+		test.modify(req)
+
+		p := newParser()
+		_, err = p.Parse(req)
+		if !errors.Is(err, webhook.ErrUnauthorized) {
+			t.Errorf("[%s] invalid error: %v", test.name, err)
+		}
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		ceID      string
+		ceType    string
+		ceSource  string
+		ceSubject string
+		team      string
+		channel   string
+		user      string
+	}{
+		{"event_callback", "Ev0LAN670R", "com.slack.event.app_mention", "/apps/A0PNCHHK2", "T061EG9R6/C0LAN2Q65", "T061EG9R6", "C0LAN2Q65", "U061F7AUR"},
+		{"block_actions", "12321423423.333649436676.d8c1bb837935619ccad0f624c448ffb3", "com.slack.interaction.block_actions", "/apps/AABA1ABCD", "T9TK3CUKW/CBR2V3XEX", "example", "review-updates", "jtorrance"},
+		{"view_submission", "12466734323.1395872398.8f3c7b2e1a9d4c6f5e0b8a7d6c5b4a39", "com.slack.interaction.view_submission", "/apps/AABA1ABCD", "T9TK3CUKW", "example", "", "jtorrance"},
+		{"shortcut", "944799105734.773906753841.38b5894552bdd4a780554ee59d1f3638", "com.slack.interaction.shortcut", "/apps/AABA1ABCD", "TXXXXXXXX", "shortcuts-test", "", "aman"},
+	}
+
+	for _, test := range tests {
+		req, err := newRequest(test.name)
+		if err != nil {
+			t.Fatalf("[%s] invalid request: %v", test.name, err)
+		}
+
+		p := newParser()
+		ce, err := p.Parse(req)
+		if err != nil {
+			t.Fatalf("[%s] parser error: %v", test.name, err)
+		}
+
+		if ce.ID != test.ceID {
+			t.Errorf("[%s] invalid ID: %v", test.name, ce.ID)
+		}
+		if ce.Type != test.ceType {
+			t.Errorf("[%s] invalid type: %v", test.name, ce.Type)
+		}
+		if ce.Source.String() != test.ceSource {
+			t.Errorf("[%s] invalid source: %v", test.name, ce.Source.String())
+		}
+		if ce.Subject != test.ceSubject {
+			t.Errorf("[%s] invalid subject: %v", test.name, ce.Subject)
+		}
+		if ce.DataContentType != "application/json" {
+			t.Errorf("[%s] invalid data content type: %v", test.name, ce.DataContentType)
+		}
+		if ce.Extensions["team"] != test.team {
+			t.Errorf("[%s] invalid team: %v", test.name, ce.Extensions["team"])
+		}
+		if ce.Extensions["channel"] != test.channel {
+			t.Errorf("[%s] invalid channel: %v", test.name, ce.Extensions["channel"])
+		}
+		if ce.Extensions["user"] != test.user {
+			t.Errorf("[%s] invalid user: %v", test.name, ce.Extensions["user"])
+		}
+	}
+}
+
+func TestParseURLVerification(t *testing.T) {
+	req, err := newRequest("url_verification")
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	p := newParser()
+	_, err = p.Parse(req)
+
+	var reply *webhook.Reply
+	if !errors.As(err, &reply) {
+		t.Fatalf("invalid error: %v", err)
+	}
+	if reply.StatusCode != http.StatusOK {
+		t.Errorf("invalid status: %d", reply.StatusCode)
+	}
+	if string(reply.Body) != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("invalid challenge: %s", reply.Body)
+	}
+}
+
+func TestNewParserOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts webhook.Options
+		err  bool
+	}{
+		{"secret", webhook.Options{"secret": Secret}, false},
+		{"insecure", webhook.Options{"insecure": true}, false},
+		{"no-secret", webhook.Options{}, true},
+		{"invalid-window", webhook.Options{"secret": Secret, "replayWindow": "5"}, true},
+	}
+
+	for _, test := range tests {
+		_, err := webhook.NewParser("slack", test.opts)
+		if test.err && err == nil {
+			t.Errorf("[%s] error expected", test.name)
+		}
+		if !test.err && err != nil {
+			t.Errorf("[%s] parser error: %v", test.name, err)
+		}
+	}
 }
@@ -1,11 +1,256 @@
 package webhook
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
+	uuid "github.com/satori/go.uuid"
 	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
 )
 
+var (
+	// ErrUnauthorized indicates that the signature or the token of
+	// the request is missing or invalid.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrBadRequest indicates that the request is malformed.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUnsupportedEvent indicates that the request is valid but the
+	// event is not supported by the parser.
+	ErrUnsupportedEvent = errors.New("unsupported event")
+	// ErrIgnored indicates that the request is valid but it does not
+	// need to be forwarded to the backend.
+	ErrIgnored = errors.New("ignored")
+	// ErrUpstream indicates that the request is valid but the parser
+	// failed to get the event from the upstream service, such as the
+	// notifier of Clair.
+	ErrUpstream = errors.New("upstream error")
+)
+
+// Error is the error returned by parsers. It wraps the cause with one
+// of the kinds of errors above, so that the kind can be tested with
+// errors.Is().
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Unauthorized returns an error of ErrUnauthorized kind.
+func Unauthorized(err error) error {
+	return &Error{Kind: ErrUnauthorized, Err: err}
+}
+
+// BadRequest returns an error of ErrBadRequest kind.
+func BadRequest(err error) error {
+	return &Error{Kind: ErrBadRequest, Err: err}
+}
+
+// UnsupportedEvent returns an error of ErrUnsupportedEvent kind.
+func UnsupportedEvent(err error) error {
+	return &Error{Kind: ErrUnsupportedEvent, Err: err}
+}
+
+// Ignored returns an error of ErrIgnored kind.
+func Ignored(err error) error {
+	return &Error{Kind: ErrIgnored, Err: err}
+}
+
+// Upstream returns an error of ErrUpstream kind.
+func Upstream(err error) error {
+	return &Error{Kind: ErrUpstream, Err: err}
+}
+
+// Reply is the error returned by parsers when the request must be
+// answered by the gateway itself, such as the verification request of
+// the webhook endpoint. The reply is responded to the sender and the
+// request is not forwarded to the backend.
+type Reply struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+func (r *Reply) Error() string {
+	return fmt.Sprintf("reply with status %d", r.StatusCode)
+}
+
+// StatusCode returns the HTTP status code to respond to the webhook
+// sender for the error returned by parsers. Errors without a kind are
+// treated as bad requests.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrUnsupportedEvent):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrIgnored):
+		return http.StatusNoContent
+	case errors.Is(err, ErrUpstream):
+		return http.StatusBadGateway
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 type Parser interface {
 	Parse(r *http.Request) (*cloudevents.Event, error)
 }
+
+// MultiParser is implemented by parsers of webhooks that carry
+// multiple events in a request. The handler uses ParseAll instead of
+// Parse if the parser implements it.
+type MultiParser interface {
+	Parser
+	ParseAll(r *http.Request) ([]*cloudevents.Event, error)
+}
+
+// StableID returns an ID derived from the values, for webhooks that do
+// not send the ID of the delivery. The same values always get the same
+// ID, so that consumers can deduplicate redelivered events. It returns
+// an empty string if all values are empty, and then the random ID is
+// assigned to the event.
+func StableID(values ...string) string {
+	if strings.Join(values, "") == "" {
+		return ""
+	}
+
+	return uuid.NewV5(uuid.NamespaceURL, strings.Join(values, "\x00")).String()
+}
+
+// Options is the parser-specific options of the route.
+type Options map[string]interface{}
+
+// String returns the value of the option as string.
+func (o Options) String(key string) string {
+	v, ok := o[key]
+	if !ok || v == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// Bool returns the value of the option as bool.
+func (o Options) Bool(key string) bool {
+	v, ok := o[key].(bool)
+	if !ok {
+		return false
+	}
+
+	return v
+}
+
+// Strings returns the value of the option as a list of string. A
+// single value is returned as a list with one element.
+func (o Options) Strings(key string) []string {
+	v, ok := o[key]
+	if !ok || v == nil {
+		return nil
+	}
+
+	if list, ok := v.([]string); ok {
+		return list
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("%v", v)}
+	}
+
+	values := []string{}
+	for _, item := range list {
+		values = append(values, fmt.Sprintf("%v", item))
+	}
+
+	return values
+}
+
+// Map returns the value of the option as nested options. Keys that
+// are not string, such as numbers in YAML, are converted to string.
+func (o Options) Map(key string) Options {
+	switch v := o[key].(type) {
+	case map[string]interface{}:
+		return Options(v)
+	case map[interface{}]interface{}:
+		m := Options{}
+		for k, item := range v {
+			m[fmt.Sprintf("%v", k)] = item
+		}
+		return m
+	}
+
+	return nil
+}
+
+// Factory returns a new parser configured with the specified options.
+type Factory func(opts Options) (Parser, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a parser available with the specified type name.
+// It panics if Register is called twice with the same name.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("webhook: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("webhook: Register called twice for parser " + name)
+	}
+
+	factories[name] = factory
+}
+
+// Types returns a sorted list of the names of registered parsers.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewParser returns a new parser of the specified type name.
+func NewParser(name string, opts Options) (Parser, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown parser type: %s", name)
+	}
+
+	if opts == nil {
+		opts = Options{}
+	}
+
+	return factory(opts)
+}
@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/summerwind/cloudevents-webhook-gateway/cloudevents"
+)
+
+type testParser struct {
+	opts Options
+}
+
+func (p *testParser) Parse(req *http.Request) (*cloudevents.Event, error) {
+	return nil, errors.New("not implemented")
+}
+
+func init() {
+	Register("test", func(opts Options) (Parser, error) {
+		if opts.Bool("invalid") {
+			return nil, errors.New("invalid option")
+		}
+		return &testParser{opts: opts}, nil
+	})
+}
+
+// loadOptions returns the options decoded from YAML as the route
+// configuration does.
+func loadOptions(t *testing.T, buf string) Options {
+	var opts map[string]interface{}
+	err := yaml.Unmarshal([]byte(buf), &opts)
+	if err != nil {
+		t.Fatalf("invalid options: %v", err)
+	}
+	return Options(opts)
+}
+
+func TestOptions(t *testing.T) {
+	opts := loadOptions(t, `
+secret: test
+port: 8080
+split: true
+quoted: "true"
+secrets: [old, new]
+numbers: [1, 2]
+appSecrets:
+  123456: app1
+  "234567": [old, new]
+  345678:
+    secretEnvs: APP3_SECRET
+`)
+
+	values := []struct {
+		key   string
+		value string
+	}{
+		{"secret", "test"},
+		{"port", "8080"},
+		{"split", "true"},
+		{"undefined", ""},
+	}
+	for _, test := range values {
+		if v := opts.String(test.key); v != test.value {
+			t.Errorf("[%s] invalid string: %q", test.key, v)
+		}
+	}
+
+	bools := []struct {
+		key   string
+		value bool
+	}{
+		{"split", true},
+		{"quoted", false},
+		{"undefined", false},
+	}
+	for _, test := range bools {
+		if v := opts.Bool(test.key); v != test.value {
+			t.Errorf("[%s] invalid bool: %v", test.key, v)
+		}
+	}
+
+	lists := []struct {
+		key   string
+		value []string
+	}{
+		{"secret", []string{"test"}},
+		{"secrets", []string{"old", "new"}},
+		{"numbers", []string{"1", "2"}},
+		{"undefined", nil},
+	}
+	for _, test := range lists {
+		if v := opts.Strings(test.key); fmt.Sprintf("%q", v) != fmt.Sprintf("%q", test.value) {
+			t.Errorf("[%s] invalid strings: %q", test.key, v)
+		}
+	}
+
+	apps := opts.Map("appSecrets")
+	if len(apps) != 3 {
+		t.Fatalf("invalid map: %v", apps)
+	}
+	if v := apps.String("123456"); v != "app1" {
+		t.Errorf("invalid value of numeric key: %q", v)
+	}
+	if v := apps.Strings("234567"); fmt.Sprint(v) != "[old new]" {
+		t.Errorf("invalid value of string key: %q", v)
+	}
+	if v := apps.Map("345678").String("secretEnvs"); v != "APP3_SECRET" {
+		t.Errorf("invalid nested map: %q", v)
+	}
+	if opts.Map("secret") != nil || opts.Map("undefined") != nil {
+		t.Errorf("non-map value must be nil")
+	}
+
+	// Options decoded from JSON have string keys.
+	opts = Options{"appSecrets": map[string]interface{}{"123456": "app1"}}
+	if v := opts.Map("appSecrets").String("123456"); v != "app1" {
+		t.Errorf("invalid value of JSON map: %q", v)
+	}
+}
+
+func TestNewParser(t *testing.T) {
+	opts := loadOptions(t, "secret: test")
+
+	p, err := NewParser("test", opts)
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+	if p.(*testParser).opts.String("secret") != "test" {
+		t.Errorf("options must be passed to the factory")
+	}
+
+	p, err = NewParser("test", nil)
+	if err != nil || p.(*testParser).opts == nil {
+		t.Errorf("empty options must be passed: %v", err)
+	}
+
+	_, err = NewParser("test", Options{"invalid": true})
+	if err == nil {
+		t.Errorf("factory error must be returned")
+	}
+
+	_, err = NewParser("unknown", nil)
+	if err == nil {
+		t.Errorf("unknown type must be rejected")
+	}
+
+	found := false
+	for _, name := range Types() {
+		if name == "test" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("registered type must be listed: %v", Types())
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate registration must panic")
+		}
+	}()
+
+	Register("test", func(opts Options) (Parser, error) {
+		return &testParser{}, nil
+	})
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errors.New("invalid"), http.StatusBadRequest},
+		{BadRequest(errors.New("invalid")), http.StatusBadRequest},
+		{Unauthorized(errors.New("invalid signature")), http.StatusUnauthorized},
+		{UnsupportedEvent(errors.New("unknown")), http.StatusUnprocessableEntity},
+		{Ignored(errors.New("ping")), http.StatusNoContent},
+		{Upstream(errors.New("unavailable")), http.StatusBadGateway},
+		{fmt.Errorf("wrapped: %w", Unauthorized(nil)), http.StatusUnauthorized},
+	}
+
+	for i, test := range tests {
+		if code := StatusCode(test.err); code != test.status {
+			t.Errorf("[%d] invalid status: %d", i, code)
+		}
+	}
+}
+
+func TestStableID(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		empty  bool
+	}{
+		{"single", []string{"a"}, false},
+		{"multiple", []string{"a", "b"}, false},
+		{"joined", []string{"ab"}, false},
+		{"partial", []string{"", "b"}, false},
+		{"empty", []string{"", ""}, true},
+		{"none", []string{}, true},
+	}
+
+	ids := map[string]string{}
+	for _, test := range tests {
+		id := StableID(test.values...)
+		if id != StableID(test.values...) {
+			t.Errorf("[%s] ID must be stable: %s", test.name, id)
+		}
+
+		if test.empty {
+			if id != "" {
+				t.Errorf("[%s] ID must be empty: %s", test.name, id)
+			}
+			continue
+		}
+
+		if id == "" {
+			t.Errorf("[%s] ID must be set", test.name)
+		}
+		if name, ok := ids[id]; ok {
+			t.Errorf("[%s] ID must differ from %s: %s", test.name, name, id)
+		}
+		ids[id] = test.name
+	}
+}